@@ -0,0 +1,377 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a problem found by Check. Unlike the changes made by Source,
+// fixing these problems could change the meaning of a program, so gofumpt only
+// reports them.
+type Diagnostic struct {
+	Pos      token.Position
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+// Severity is how important a Diagnostic is. Higher severities are more
+// important, so they can be compared to filter diagnostics.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota + 1
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// defaultSeverities holds the severity of each rule, unless overridden by
+// Options.RuleSeverities.
+var defaultSeverities = map[string]Severity{
+	"context-first":    SeverityWarning,
+	"linkname":         SeverityError,
+	"redundant-return": SeverityInfo,
+	"package-comment":  SeverityWarning,
+	"block-depth":      SeverityWarning,
+	"init-scattered":   SeverityInfo,
+	"exported-doc":     SeverityWarning,
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// Check reports the problems found in src, assuming that it holds a valid Go
+// source file. The source is not formatted; Source should be used for that.
+//
+// Each rule has a default severity, shown below, which can be changed via
+// Options.RuleSeverities.
+//
+// The following rules are always checked:
+//
+//     context-first  warning  context.Context should be the first parameter
+//     linkname       error    //go:linkname directives should have one or two arguments
+//
+// Other rules are only checked when enabled via Options:
+//
+//     redundant-return  info     a func without results shouldn't end with a bare return
+//     package-comment   warning  the package clause shouldn't have an inline comment
+//     block-depth       warning  blocks shouldn't be nested too deeply
+//     init-scattered    info     init funcs should be grouped together
+//     exported-doc      warning  exported declarations should have doc comments
+func Check(src []byte, opts Options) ([]Diagnostic, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		Options: opts,
+		fset:    fset,
+		astFile: file,
+	}
+	c.contextName = c.importName("context")
+	if opts.FlagPackageInlineComment {
+		c.checkPackageComment()
+	}
+	if opts.FlagScatteredInits {
+		c.checkScatteredInits()
+	}
+	if opts.RequireExportedDocs {
+		c.checkExportedDocs()
+	}
+	if opts.MaxBlockDepth > 0 {
+		c.checkBlockDepth(file, 0)
+	}
+	ast.Inspect(file, c.check)
+
+	// Comments aren't nodes, so they're not walked by ast.Inspect.
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			c.checkDirective(comment)
+		}
+	}
+	return c.diags, nil
+}
+
+type checker struct {
+	Options
+
+	fset    *token.FileSet
+	astFile *ast.File
+
+	// contextName is the name under which the "context" package is
+	// imported, if it is imported at all.
+	contextName string
+
+	diags []Diagnostic
+}
+
+func (c *checker) report(pos token.Pos, rule, format string, args ...interface{}) {
+	severity := c.RuleSeverities[rule]
+	if severity == 0 {
+		severity = defaultSeverities[rule]
+	}
+	c.diags = append(c.diags, Diagnostic{
+		Pos:      c.fset.Position(pos),
+		Rule:     rule,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// importName returns the name under which path is imported, or an empty string
+// if it isn't imported or it is imported as a blank or dot import.
+func (c *checker) importName(path string) string {
+	for _, spec := range c.astFile.Imports {
+		if p, _ := strconv.Unquote(spec.Path.Value); p != path {
+			continue
+		}
+		if spec.Name == nil {
+			return path
+		}
+		if name := spec.Name.Name; name != "_" && name != "." {
+			return name
+		}
+	}
+	return ""
+}
+
+func (c *checker) check(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.FuncType:
+		c.checkContextFirst(node)
+	case *ast.FuncDecl:
+		if c.FlagRedundantReturn {
+			c.checkRedundantReturn(node.Type, node.Body)
+		}
+	case *ast.FuncLit:
+		if c.FlagRedundantReturn {
+			c.checkRedundantReturn(node.Type, node.Body)
+		}
+	}
+	return true
+}
+
+// checkPackageComment reports an inline comment following the package clause.
+// Package documentation belongs in a doc comment before the package clause.
+// We don't remove these comments, as their content could be lost.
+func (c *checker) checkPackageComment() {
+	name := c.astFile.Name
+	line := c.fset.Position(name.End()).Line
+	for _, group := range c.astFile.Comments {
+		if group.Pos() < name.End() {
+			continue
+		}
+		if c.fset.Position(group.Pos()).Line == line {
+			c.report(group.Pos(), "package-comment",
+				"the package clause shouldn't have an inline comment")
+		}
+		break
+	}
+}
+
+// checkScatteredInits reports init funcs which don't directly follow another
+// init func, when there was an earlier init func in the file.
+func (c *checker) checkScatteredInits() {
+	seenInit, lastInit := false, false
+	for _, decl := range c.astFile.Decls {
+		init := isInitFunc(decl)
+		if init && seenInit && !lastInit {
+			c.report(decl.Pos(), "init-scattered",
+				"init func should be grouped with the earlier init funcs")
+		}
+		seenInit = seenInit || init
+		lastInit = init
+	}
+}
+
+// checkExportedDocs reports exported top-level declarations which don't have
+// a doc comment, either on themselves or on their declaration group. Methods
+// are only reported if their receiver type is exported too.
+func (c *checker) checkExportedDocs() {
+	for _, decl := range c.astFile.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Doc != nil || !decl.Name.IsExported() {
+				continue
+			}
+			if decl.Recv == nil {
+				c.report(decl.Pos(), "exported-doc",
+					"exported func %s should have a doc comment", decl.Name.Name)
+				continue
+			}
+			recv := recvTypeName(decl.Recv.List[0].Type)
+			if ast.IsExported(recv) {
+				c.report(decl.Pos(), "exported-doc",
+					"exported method %s.%s should have a doc comment", recv, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			if decl.Doc != nil || decl.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Doc == nil && spec.Name.IsExported() {
+						c.report(spec.Pos(), "exported-doc",
+							"exported type %s should have a doc comment", spec.Name.Name)
+					}
+				case *ast.ValueSpec:
+					if spec.Doc != nil {
+						continue
+					}
+					for _, name := range spec.Names {
+						if name.IsExported() {
+							c.report(name.Pos(), "exported-doc",
+								"exported %s %s should have a doc comment", decl.Tok, name.Name)
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// recvTypeName returns the name of a method's receiver type, such as "T" for
+// "*T" or "T[E]".
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch x := expr.(type) {
+		case *ast.StarExpr:
+			expr = x.X
+		case *ast.ParenExpr:
+			expr = x.X
+		case *ast.IndexExpr:
+			expr = x.X
+		case *ast.IndexListExpr:
+			expr = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// checkBlockDepth reports blocks within node which are nested more than
+// MaxBlockDepth levels deep, given that node is at the given depth. Only the
+// deepest block is reported for each block crossing the limit, to not report
+// every level of a deeply nested chain.
+func (c *checker) checkBlockDepth(node ast.Node, depth int) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		block, ok := n.(*ast.BlockStmt)
+		if !ok || n == node {
+			return true
+		}
+		if depth+1 <= c.MaxBlockDepth {
+			c.checkBlockDepth(block, depth+1)
+			return false
+		}
+		deepest, deepestDepth := deepestBlock(block, depth+1)
+		c.report(deepest.Lbrace, "block-depth",
+			"block nested %d levels deep, more than the maximum of %d",
+			deepestDepth, c.MaxBlockDepth)
+		return false
+	})
+}
+
+// deepestBlock returns the most deeply nested block within block, including
+// itself, along with its depth. Ties are broken by source order.
+func deepestBlock(block *ast.BlockStmt, depth int) (*ast.BlockStmt, int) {
+	deepest, deepestDepth := block, depth
+	ast.Inspect(block, func(n ast.Node) bool {
+		inner, ok := n.(*ast.BlockStmt)
+		if !ok || inner == block {
+			return true
+		}
+		if b, d := deepestBlock(inner, depth+1); d > deepestDepth {
+			deepest, deepestDepth = b, d
+		}
+		return false
+	})
+	return deepest, deepestDepth
+}
+
+// checkRedundantReturn reports a bare return at the end of a func body, when
+// the func has no results.
+func (c *checker) checkRedundantReturn(ft *ast.FuncType, body *ast.BlockStmt) {
+	if body == nil || len(body.List) == 0 || ft.Results.NumFields() > 0 {
+		return
+	}
+	ret, ok := body.List[len(body.List)-1].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) > 0 {
+		return
+	}
+	c.report(ret.Pos(), "redundant-return",
+		"redundant return at the end of a func without results")
+}
+
+// checkContextFirst reports context.Context parameters which aren't the first
+// parameter. Without type information, we can only recognise the type by its
+// qualified name.
+func (c *checker) checkContextFirst(ft *ast.FuncType) {
+	if c.contextName == "" || ft.Params == nil {
+		return
+	}
+	index := 0
+	for _, field := range ft.Params.List {
+		sel, ok := field.Type.(*ast.SelectorExpr)
+		isContext := ok && identEqual(sel.X, c.contextName) && sel.Sel.Name == "Context"
+
+		// Unnamed parameters still take up one position.
+		positions := []token.Pos{field.Pos()}
+		if len(field.Names) > 0 {
+			positions = positions[:0]
+			for _, name := range field.Names {
+				positions = append(positions, name.Pos())
+			}
+		}
+		for _, pos := range positions {
+			if isContext && index > 0 {
+				c.report(pos, "context-first",
+					"context.Context should be the first parameter")
+			}
+			index++
+		}
+	}
+}
+
+// checkDirective reports malformed compiler directives. For now, only
+// go:linkname is checked, which has the form:
+//
+//     //go:linkname localname [importpath.name]
+func (c *checker) checkDirective(comment *ast.Comment) {
+	body := strings.TrimPrefix(comment.Text, "//")
+	if body == comment.Text || !rxCommentDirective.MatchString(body) {
+		return // not a directive
+	}
+	fields := strings.Fields(body)
+	if fields[0] != "go:linkname" {
+		return
+	}
+	if n := len(fields) - 1; n < 1 || n > 2 {
+		c.report(comment.Pos(), "linkname",
+			"//go:linkname should have one or two arguments, found %d", n)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format_test
+
+import (
+	"reflect"
+	"testing"
+
+	"mvdan.cc/gofumpt/format"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts format.Options
+		in   string
+		want []string
+	}{
+		{
+			name: "ContextFirst",
+			in: `package p
+
+import "context"
+
+func first(ctx context.Context, s string) {}
+
+func later(s string, ctx context.Context) {}
+
+func merged(ctx1, ctx2 context.Context) {}
+
+func unnamed(int, context.Context) {}
+
+func none(s string) {}
+
+type I interface {
+	M(s string, ctx context.Context)
+}
+`,
+			want: []string{
+				"7:22: context.Context should be the first parameter",
+				"9:19: context.Context should be the first parameter",
+				"11:19: context.Context should be the first parameter",
+				"16:14: context.Context should be the first parameter",
+			},
+		},
+		{
+			name: "ContextRenamed",
+			in: `package p
+
+import stdctx "context"
+
+func later(s string, ctx stdctx.Context) {}
+`,
+			want: []string{
+				"5:22: context.Context should be the first parameter",
+			},
+		},
+		{
+			name: "ContextNotImported",
+			in: `package p
+
+func later(s string, ctx context.Context) {}
+`,
+		},
+		{
+			name: "Linkname",
+			in: `package p
+
+import _ "unsafe"
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+//go:linkname exported
+func exported() {}
+
+//go:linkname
+func missing() int64
+
+//go:linkname toomany runtime.nanotime extra
+func toomany() int64
+
+// go:linkname notadirective
+func notadirective() int64
+`,
+			want: []string{
+				"11:1: //go:linkname should have one or two arguments, found 0",
+				"14:1: //go:linkname should have one or two arguments, found 3",
+			},
+		},
+		{
+			name: "RedundantReturnDisabled",
+			in: `package p
+
+func f() {
+	println()
+	return
+}
+`,
+		},
+		{
+			name: "RedundantReturn",
+			opts: format.Options{FlagRedundantReturn: true},
+			in: `package p
+
+func trailing() {
+	println()
+	return
+}
+
+func onlyReturn() {
+	return
+}
+
+func noReturn() {
+	println()
+}
+
+func early(b bool) {
+	if b {
+		return
+	}
+	println()
+}
+
+func results() (err error) {
+	return
+}
+
+var lit = func() {
+	println()
+	return
+}
+`,
+			want: []string{
+				"5:2: redundant return at the end of a func without results",
+				"9:2: redundant return at the end of a func without results",
+				"29:2: redundant return at the end of a func without results",
+			},
+		},
+		{
+			name: "PackageInlineCommentDisabled",
+			in: `package p // note
+`,
+		},
+		{
+			name: "PackageInlineComment",
+			opts: format.Options{FlagPackageInlineComment: true},
+			in: `// Package p does things.
+package p /* note */ // another note
+
+// next line
+`,
+			want: []string{
+				"2:11: the package clause shouldn't have an inline comment",
+			},
+		},
+		{
+			name: "PackageNoInlineComment",
+			opts: format.Options{FlagPackageInlineComment: true},
+			in: `// Package p does things.
+package p
+
+// next line
+`,
+		},
+		{
+			name: "BlockDepthDisabled",
+			in: `package p
+
+func f() {
+	for {
+		if true {
+			println()
+		}
+	}
+}
+`,
+		},
+		{
+			name: "BlockDepth",
+			opts: format.Options{MaxBlockDepth: 2},
+			in: `package p
+
+func atLimit() {
+	if true {
+		println()
+	}
+}
+
+func beyond() {
+	for {
+		if true {
+			switch {
+			case true:
+				func() {
+					println()
+				}()
+			}
+		}
+		if false {
+			println()
+		}
+	}
+}
+
+func separate() {
+	if true {
+		{
+		}
+	}
+	if false {
+		{
+		}
+	}
+}
+`,
+			want: []string{
+				"14:12: block nested 5 levels deep, more than the maximum of 2",
+				"19:12: block nested 3 levels deep, more than the maximum of 2",
+				"27:3: block nested 3 levels deep, more than the maximum of 2",
+				"31:3: block nested 3 levels deep, more than the maximum of 2",
+			},
+		},
+		{
+			name: "ScatteredInits",
+			opts: format.Options{FlagScatteredInits: true},
+			in: `package p
+
+func init() {}
+
+func init() {}
+
+var x int
+
+func init() {}
+
+func (T) init() {}
+
+func init() {}
+`,
+			want: []string{
+				"9:1: init func should be grouped with the earlier init funcs",
+				"13:1: init func should be grouped with the earlier init funcs",
+			},
+		},
+		{
+			name: "GroupedInits",
+			opts: format.Options{FlagScatteredInits: true},
+			in: `package p
+
+var x int
+
+func init() {}
+
+func init() {}
+
+var y int
+`,
+		},
+		{
+			name: "ExportedDocs",
+			opts: format.Options{RequireExportedDocs: true},
+			in: `package p
+
+import "fmt"
+
+// Documented does things.
+func Documented() {}
+
+func Undocumented() {}
+
+func unexported() {}
+
+type T struct{}
+
+// M is documented.
+func (T) M() {}
+
+func (*T) N() {}
+
+func (t) M() {}
+
+// Group covers all of its declarations.
+const (
+	A = 1
+	B = 2
+)
+
+var (
+	// C is documented.
+	C = 3
+	D, e = 4, 5
+	f, G = 6, 7
+	h = 8
+)
+
+type (
+	// U is documented.
+	U int
+	V int
+)
+
+var _ = fmt.Sprint
+`,
+			want: []string{
+				"8:1: exported func Undocumented should have a doc comment",
+				"12:6: exported type T should have a doc comment",
+				"17:1: exported method T.N should have a doc comment",
+				"30:2: exported var D should have a doc comment",
+				"31:5: exported var G should have a doc comment",
+				"38:2: exported type V should have a doc comment",
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			diags, err := format.Check([]byte(test.in), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, diag := range diags {
+				got = append(got, diag.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got:\n%q\nwant:\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckSeverities(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+import "context"
+
+//go:linkname
+func nanotime() int64
+
+func later(s string, ctx context.Context) {}
+
+func trailing() {
+	return
+}
+`)
+	tests := []struct {
+		name       string
+		severities map[string]format.Severity
+		want       []format.Severity
+	}{
+		{
+			name: "Defaults",
+			want: []format.Severity{
+				format.SeverityWarning,
+				format.SeverityInfo,
+				format.SeverityError,
+			},
+		},
+		{
+			name: "Overridden",
+			severities: map[string]format.Severity{
+				"context-first":    format.SeverityError,
+				"redundant-return": format.SeverityWarning,
+			},
+			want: []format.Severity{
+				format.SeverityError,
+				format.SeverityWarning,
+				format.SeverityError,
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			diags, err := format.Check(in, format.Options{
+				FlagRedundantReturn: true,
+				RuleSeverities:      test.severities,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []format.Severity
+			for _, diag := range diags {
+				got = append(got, diag.Severity)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
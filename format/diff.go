@@ -0,0 +1,356 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode/utf16"
+)
+
+// Hunk is a group of consecutive changed lines between two versions of a
+// file. Line numbers start at 1. If Old is empty, the lines in New are
+// inserted before line OldStart; likewise for NewStart if New is empty.
+type Hunk struct {
+	OldStart, NewStart int
+
+	// Old and New are the removed and added lines, each including its
+	// trailing newline, if any.
+	Old, New []string
+}
+
+// GofumptOnlyDiff returns the changes that gofumpt makes on top of gofmt, as
+// hunks between the output of go/format.Source and that of Source. Changes
+// which gofmt would make on its own, such as fixing indentation, are not
+// included.
+func GofumptOnlyDiff(src []byte, opts Options) ([]Hunk, error) {
+	gofmtRes, err := format.Source(src)
+	if err != nil {
+		return nil, err
+	}
+	gofumptRes, err := Source(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	return diffLines(splitLines(string(gofmtRes)), splitLines(string(gofumptRes))), nil
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the hunks which turn the lines in a into the lines in b.
+// It uses Myers' O(ND) algorithm, which finds a shortest edit script.
+func diffLines(a, b []string) []Hunk {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds v as it was before step d, to backtrack later. Only
+	// the diagonals from -d-1 to d+1 are read when backtracking from step
+	// d, so only those are kept, with trace[d][d+1+k] holding diagonal k.
+	// This keeps the memory use at O(D²) rather than O(D·(N+M)).
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down, an insertion
+			} else {
+				x = v[offset+k-1] + 1 // right, a deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end, recording the edit script in reverse.
+	const (
+		opEqual = iota
+		opDelete
+		opInsert
+	)
+	var ops []int
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[d+k] < v[d+k+2]) {
+			prevK = k + 1
+		}
+		prevX := v[d+1+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, opEqual)
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, opInsert)
+			} else {
+				ops = append(ops, opDelete)
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	var hunks []Hunk
+	var cur *Hunk
+	i, j := 0, 0
+	for idx := len(ops) - 1; idx >= 0; idx-- {
+		op := ops[idx]
+		if op == opEqual {
+			cur = nil
+			i++
+			j++
+			continue
+		}
+		if cur == nil {
+			hunks = append(hunks, Hunk{OldStart: i + 1, NewStart: j + 1})
+			cur = &hunks[len(hunks)-1]
+		}
+		if op == opDelete {
+			cur.Old = append(cur.Old, a[i])
+			i++
+		} else {
+			cur.New = append(cur.New, b[j])
+			j++
+		}
+	}
+	return hunks
+}
+
+// TextEdit replaces the text between Start and End with NewText, like an LSP
+// TextEdit.
+type TextEdit struct {
+	Start, End TextPosition
+	NewText    string
+}
+
+// TextPosition is a position in a file, like an LSP Position. Line and
+// Character start at 0, and Character counts units of a PositionEncoding.
+type TextPosition struct {
+	Line, Character int
+}
+
+// PositionEncoding is the unit which TextPosition.Character counts, like the
+// position encodings which LSP clients and servers agree on.
+type PositionEncoding int
+
+const (
+	// PositionUTF16 counts UTF-16 code units, which is the LSP default.
+	PositionUTF16 PositionEncoding = iota
+
+	// PositionUTF8 counts bytes.
+	PositionUTF8
+)
+
+// TextEdits formats src like Source, and returns the changes as a minimal
+// list of edits to src instead of the whole result. The edits are sorted and
+// don't overlap, so they can be applied in order, like LSP formatting edits.
+//
+// Each edit replaces whole lines, so its positions are at the start of a
+// line, apart from the end of a last line without a trailing newline. The
+// Character of such a position counts units of enc.
+func TextEdits(src []byte, enc PositionEncoding, opts Options) ([]TextEdit, error) {
+	res, err := Source(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	old := splitLines(string(src))
+	hunks := diffLines(old, splitLines(string(res)))
+	var edits []TextEdit
+	for _, h := range hunks {
+		edits = append(edits, TextEdit{
+			Start:   TextPosition{Line: h.OldStart - 1},
+			End:     lineEndPosition(old, h.OldStart-1+len(h.Old), enc),
+			NewText: strings.Join(h.New, ""),
+		})
+	}
+	return edits, nil
+}
+
+// SourceRange formats src like Source, but only keeps the changes to the lines
+// which overlap the byte offsets [start, end) in src, such as the region which
+// a user just edited; the rest of src is left as-is. An empty range keeps the
+// changes to the line holding start. The whole file is still parsed, so src
+// must be valid Go.
+//
+// Changes are kept or dropped per hunk of changed lines, as found by diffing
+// src with the formatted result, rather than per syntax node. A hunk which
+// overlaps the range is kept whole, even where it extends past the range, and
+// a hunk which doesn't overlap it is dropped whole. Since a hunk covers all the
+// adjacent lines which changed, a change right outside the range is kept if it
+// is on a line next to a change within the range, and a change to the range
+// which is tied to other lines, like an empty line being removed, may extend
+// past it.
+func SourceRange(src []byte, start, end int, opts Options) ([]byte, error) {
+	if start < 0 || start > end || end > len(src) {
+		return nil, fmt.Errorf("invalid range [%d, %d) for %d bytes of source", start, end, len(src))
+	}
+	res, err := Source(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	old := splitLines(string(src))
+	hunks := diffLines(old, splitLines(string(res)))
+
+	// Treat empty ranges, such as a cursor or an inserted line, as
+	// covering the byte right after them.
+	if end == start {
+		end++
+	}
+	var buf bytes.Buffer
+	line, offset := 0, 0 // the next line in old to copy, and its offset
+	for _, h := range hunks {
+		for ; line < h.OldStart-1; line++ {
+			buf.WriteString(old[line])
+			offset += len(old[line])
+		}
+		oldText := strings.Join(h.Old, "")
+		hunkStart, hunkEnd := offset, offset+len(oldText)
+		if hunkEnd == hunkStart {
+			hunkEnd++ // an insertion; likewise
+		}
+		if hunkStart < end && start < hunkEnd {
+			buf.WriteString(strings.Join(h.New, ""))
+		} else {
+			buf.WriteString(oldText)
+		}
+		line += len(h.Old)
+		offset += len(oldText)
+	}
+	for ; line < len(old); line++ {
+		buf.WriteString(old[line])
+	}
+	return buf.Bytes(), nil
+}
+
+// diffContext is the number of unchanged lines shown around the changes in
+// Diff, like the default of diff -u.
+const diffContext = 3
+
+// Diff formats src like SourceFile, and returns the changes as a unified diff
+// from the original to the formatted source, with git-style headers using
+// filename. If src is already formatted, Diff returns nil.
+func Diff(filename string, src []byte, opts Options) ([]byte, error) {
+	res, err := SourceFile(filename, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(src, res) {
+		return nil, nil
+	}
+	old := splitLines(string(src))
+	hunks := diffLines(old, splitLines(string(res)))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", filename, filename)
+	for len(hunks) > 0 {
+		// Join the hunks whose context lines would touch or overlap.
+		n := 1
+		for n < len(hunks) && hunks[n].OldStart-hunkEnd(hunks[n-1]) <= 2*diffContext {
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		first, last := group[0], group[len(group)-1]
+		oldFrom := first.OldStart - 1 - diffContext
+		if oldFrom < 0 {
+			oldFrom = 0
+		}
+		oldTo := hunkEnd(last) - 1 + diffContext
+		if oldTo > len(old) {
+			oldTo = len(old)
+		}
+		// The lines outside the hunks are the same in both versions,
+		// so they are simply offset.
+		newFrom := oldFrom + first.NewStart - first.OldStart
+		newTo := oldTo + (last.NewStart + len(last.New)) - hunkEnd(last)
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			unifiedRange(oldFrom, oldTo), unifiedRange(newFrom, newTo))
+
+		line := oldFrom
+		for _, h := range group {
+			for ; line < h.OldStart-1; line++ {
+				writeDiffLine(&buf, ' ', old[line])
+			}
+			for _, l := range h.Old {
+				writeDiffLine(&buf, '-', l)
+			}
+			for _, l := range h.New {
+				writeDiffLine(&buf, '+', l)
+			}
+			line += len(h.Old)
+		}
+		for ; line < oldTo; line++ {
+			writeDiffLine(&buf, ' ', old[line])
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// hunkEnd returns the line number in the old version right after h.
+func hunkEnd(h Hunk) int {
+	return h.OldStart + len(h.Old)
+}
+
+// unifiedRange formats the lines with indexes [from, to) as a range in a
+// unified diff hunk header. Like GNU diff, an empty range is written as the
+// line before it.
+func unifiedRange(from, to int) string {
+	switch to - from {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprintf("%d", from+1)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+// writeDiffLine writes a line of a unified diff, noting if it has no trailing
+// newline.
+func writeDiffLine(buf *bytes.Buffer, prefix byte, line string) {
+	buf.WriteByte(prefix)
+	buf.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		buf.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// lineEndPosition returns the position at the start of the line with the
+// given index. If the line is past the end of the file and the last line has
+// no trailing newline, the end of the last line is returned instead.
+func lineEndPosition(lines []string, line int, enc PositionEncoding) TextPosition {
+	if line == len(lines) && line > 0 {
+		last := lines[line-1]
+		if !strings.HasSuffix(last, "\n") {
+			char := len(last)
+			if enc == PositionUTF16 {
+				char = len(utf16.Encode([]rune(last)))
+			}
+			return TextPosition{Line: line - 1, Character: char}
+		}
+	}
+	return TextPosition{Line: line}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// checkEquivalent returns an error if the source files src and res don't have
+// the same syntax tree, once positions, comments, and the rewrites which
+// gofumpt makes on purpose are ignored.
+func checkEquivalent(filename string, src, res []byte, opts Options) error {
+	var files [2]*ast.File
+	for i, b := range [2][]byte{src, res} {
+		file, err := parser.ParseFile(token.NewFileSet(), filename, b, 0)
+		if err != nil {
+			return err
+		}
+		if opts.Simplify {
+			// Simplifying twice is a no-op.
+			simplify(file, func(token.Pos) {})
+		}
+		if opts.UnifyReceiverNames {
+			// Receivers may be renamed; the output is unchanged.
+			unifyReceiverNames(file)
+		}
+		normalizeFile(file, opts)
+		files[i] = file
+	}
+	diff := cmp.Diff(files[0], files[1],
+		cmp.Comparer(func(x, y token.Pos) bool { return true }),
+		cmpopts.IgnoreTypes(&ast.Object{}, &ast.Scope{}, &ast.CommentGroup{}),
+	)
+	if diff != "" {
+		return fmt.Errorf("formatting changed the syntax tree (-before +after):\n%s", diff)
+	}
+	return nil
+}
+
+// normalizeFile rewrites a file into a canonical form, undoing the rewrites
+// which don't change the meaning of a program, such as grouping declarations
+// or changing the base of a number literal.
+func normalizeFile(file *ast.File, opts Options) {
+	// Imports may be merged and reordered; keep them all at the top, one
+	// per declaration, sorted by path. Other declarations may be grouped
+	// or split, so keep one spec per declaration.
+	var imports, decls []ast.Decl
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			decls = append(decls, decl)
+			continue
+		}
+		for _, spec := range gen.Specs {
+			single := &ast.GenDecl{Tok: gen.Tok, Specs: []ast.Spec{spec}}
+			if gen.Tok == token.IMPORT {
+				imports = append(imports, single)
+			} else {
+				decls = append(decls, single)
+			}
+		}
+	}
+	sort.SliceStable(imports, func(i, j int) bool {
+		spec1 := imports[i].(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
+		spec2 := imports[j].(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
+		return spec1.Path.Value < spec2.Path.Value
+	})
+	file.Decls = append(imports, decls...)
+	file.Imports = nil
+	file.Unresolved = nil
+
+	astutil.Apply(file, func(c *astutil.Cursor) bool {
+		switch node := c.Node().(type) {
+		case *ast.DeclStmt:
+			// "var name = value" becomes "name := value".
+			decl, ok := node.Decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.VAR || len(decl.Specs) != 1 {
+				break
+			}
+			spec := decl.Specs[0].(*ast.ValueSpec)
+			if spec.Type != nil || len(spec.Values) == 0 {
+				break
+			}
+			tok := token.ASSIGN
+			names := make([]ast.Expr, len(spec.Names))
+			for i, name := range spec.Names {
+				names[i] = name
+				if name.Name != "_" {
+					tok = token.DEFINE
+				}
+			}
+			c.Replace(&ast.AssignStmt{Lhs: names, Tok: tok, Rhs: spec.Values})
+		case *ast.CallExpr:
+			// "int(int(x))" becomes "int(x)" with ExtraRules.
+			outer, ok := node.Fun.(*ast.Ident)
+			if !ok || !predeclaredTypes[outer.Name] || outer.Obj != nil ||
+				len(node.Args) != 1 || node.Ellipsis.IsValid() {
+				break
+			}
+			for {
+				inner, ok := node.Args[0].(*ast.CallExpr)
+				if !ok || !identEqual(inner.Fun, outer.Name) || inner.Fun.(*ast.Ident).Obj != nil ||
+					len(inner.Args) != 1 || inner.Ellipsis.IsValid() {
+					break
+				}
+				node.Args[0] = inner.Args[0]
+			}
+		case *ast.ForStmt:
+			// "for true {" becomes "for {".
+			if node.Init == nil && node.Post == nil && identEqual(node.Cond, "true") &&
+				node.Cond.(*ast.Ident).Obj == nil {
+				node.Cond = nil
+			}
+		case *ast.IfStmt:
+			// Empty else blocks may be removed.
+			if block, ok := node.Else.(*ast.BlockStmt); ok && len(withoutEmptyStmts(block.List)) == 0 {
+				node.Else = nil
+			}
+		case *ast.BlockStmt:
+			// Empty statements may be removed.
+			node.List = withoutEmptyStmts(node.List)
+			if opts.FlattenElse {
+				node.List = withFlatElse(node.List)
+			}
+		case *ast.CaseClause:
+			node.Body = withoutEmptyStmts(node.Body)
+		case *ast.CommClause:
+			node.Body = withoutEmptyStmts(node.Body)
+		case *ast.FuncType:
+			// go/printer drops empty result lists, like in "func() ()".
+			if node.Results != nil && len(node.Results.List) == 0 {
+				node.Results = nil
+			}
+		case *ast.FieldList:
+			// Adjacent fields of the same type may be merged, so keep
+			// one name per field.
+			var list []*ast.Field
+			for _, field := range node.List {
+				if len(field.Names) < 2 {
+					list = append(list, field)
+					continue
+				}
+				for _, name := range field.Names {
+					split := *field
+					split.Names = []*ast.Ident{name}
+					list = append(list, &split)
+				}
+			}
+			node.List = list
+		case *ast.InterfaceType:
+			// Empty interfaces may be written as "any".
+			if node.Methods.NumFields() == 0 {
+				c.Replace(&ast.Ident{Name: "any"})
+			}
+		case *ast.ParenExpr:
+			// Redundant parentheses may be removed, and the syntax tree
+			// already reflects precedence without them.
+			var x ast.Expr = node
+			for {
+				paren, ok := x.(*ast.ParenExpr)
+				if !ok {
+					break
+				}
+				x = paren.X
+			}
+			c.Replace(x)
+		case *ast.Field:
+			// Struct tags may be respaced, which changes their value.
+			if node.Tag != nil && opts.NormalizeTags {
+				tag, err := strconv.Unquote(node.Tag.Value)
+				if norm, ok := normalizeTag(tag); err == nil && ok {
+					node.Tag.Value = strconv.Quote(norm)
+				}
+			}
+		case *ast.BasicLit:
+			node.Value = normalizeLiteral(node, opts)
+		}
+		return true
+	}, nil)
+}
+
+// withoutEmptyStmts returns the statements in list which aren't empty.
+func withoutEmptyStmts(list []ast.Stmt) []ast.Stmt {
+	var kept []ast.Stmt
+	for _, stmt := range list {
+		if _, ok := stmt.(*ast.EmptyStmt); !ok {
+			kept = append(kept, stmt)
+		}
+	}
+	return kept
+}
+
+// withFlatElse returns list with the else blocks after terminating if blocks
+// moved after their if statements, like FlattenElse.
+func withFlatElse(list []ast.Stmt) []ast.Stmt {
+	for i := 0; i < len(list); i++ {
+		ifs, ok := list[i].(*ast.IfStmt)
+		if !ok || ifs.Init != nil || len(ifs.Body.List) == 0 ||
+			!isTerminating(ifs.Body.List[len(ifs.Body.List)-1]) {
+			continue
+		}
+		block, ok := ifs.Else.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+		ifs.Else = nil
+		flat := append(append([]ast.Stmt(nil), list[:i+1]...), block.List...)
+		list = append(flat, list[i+1:]...)
+	}
+	return list
+}
+
+// normalizeLiteral returns the canonical form of a literal's value, so that
+// literals with the same value but a different spelling, such as 0755 and
+// 0o755, are equal.
+func normalizeLiteral(lit *ast.BasicLit, opts Options) string {
+	if lit.Kind == token.STRING && lit.Value[0] == '`' && len(opts.RawStringFormatters) > 0 {
+		// The contents of raw strings may be changed on purpose.
+		return "``"
+	}
+	value := constant.MakeFromLiteral(lit.Value, lit.Kind, 0)
+	if value.Kind() == constant.Unknown {
+		return lit.Value
+	}
+	if lit.Kind == token.STRING {
+		return strconv.Quote(constant.StringVal(value))
+	}
+	return value.ExactString()
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import "go/ast"
+
+// SetTestHookFile sets testHookFile, returning a func to restore it.
+func SetTestHookFile(fn func(*ast.File)) (restore func()) {
+	old := testHookFile
+	testHookFile = fn
+	return func() { testHookFile = old }
+}
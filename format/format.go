@@ -12,9 +12,10 @@ import (
 	"go/ast"
 	"go/format"
 	"go/parser"
+	"go/printer"
 	"go/token"
 	"os"
-	"reflect"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -41,31 +42,472 @@ type Options struct {
 	// not use language features which could break programs.
 	LangVersion string
 
+	// DetectLangVersion sets LangVersion from the go.mod file of the module
+	// holding the formatted file, if LangVersion is empty and a filename is
+	// given, such as via SourceFile. See the DetectLangVersion func.
+	DetectLangVersion bool
+
 	ExtraRules bool
+
+	// NoBlankAfterMultilineCondition removes the empty line which is
+	// otherwise kept between a multi-line if or for condition and the
+	// start of its body.
+	NoBlankAfterMultilineCondition bool
+
+	// StampHeader adds a "//gofumpt:version" comment noting the version of
+	// gofumpt which formatted the source, placed after any leading comments
+	// such as build constraints or a license header, and before the package
+	// clause and its documentation. An
+	// existing stamp is updated in place. Since the stamp is added when
+	// printing, it is only used by Source.
+	StampHeader bool
+
+	// CollapseShortInterfaces joins interface types with a single method
+	// onto a single line, if they are short and have no comments.
+	CollapseShortInterfaces bool
+
+	// FlagRedundantReturn makes Check report bare returns at the end of
+	// funcs without results. Such returns are left alone when formatting.
+	FlagRedundantReturn bool
+
+	// FlagPackageInlineComment makes Check report inline comments after
+	// the package clause, like "package p // note". The comments are left
+	// alone when formatting.
+	FlagPackageInlineComment bool
+
+	// CollapseShortCalls joins calls with each argument on a separate line
+	// onto a single line, if they are short and have no comments.
+	CollapseShortCalls bool
+
+	// CollapseShortTypeParams joins type parameter lists with each
+	// parameter on a separate line onto a single line, if they are short
+	// and have no comments.
+	CollapseShortTypeParams bool
+
+	// TrailingCommas puts the closing parenthesis or bracket of calls and
+	// type parameter lists whose elements are split across lines on a line
+	// of its own, so that go/printer adds a trailing comma after the last
+	// element. Adding or removing an element then doesn't change the line
+	// holding the last one.
+	TrailingCommas bool
+
+	// MaxBlockDepth makes Check report blocks nested more than this many
+	// levels deep, counting a func body as the first level. Zero disables
+	// the check.
+	MaxBlockDepth int
+
+	// MaxInputBytes makes Source return an error without parsing if the
+	// source is larger than this many bytes, to bound the work done on
+	// untrusted input. Zero means no limit.
+	MaxInputBytes int
+
+	// RawStringFormatters formats the contents of raw string literals
+	// which follow a marker comment like "//sql:", keyed by the marker's
+	// name, such as "sql". Unmarked raw strings are never modified, and
+	// neither are results which can't be represented as a raw string.
+	RawStringFormatters map[string]func(string) string
+
+	// SeparateInitFuncs separates init funcs from the surrounding
+	// top-level declarations with empty lines, even if they are single-line.
+	SeparateInitFuncs bool
+
+	// SeparateFuncDecls separates adjacent top-level funcs and methods with
+	// an empty line, even if they are single-line. An empty line is added
+	// before the doc comment of a func, if it has one.
+	SeparateFuncDecls bool
+
+	// FlagScatteredInits makes Check report init funcs which aren't
+	// grouped together with the previous init func in the file.
+	FlagScatteredInits bool
+
+	// VerifyOutput makes Source parse its own output, and return an error
+	// if it's not valid Go. That would be a bug in gofumpt, but this way it
+	// can't silently corrupt the source.
+	VerifyOutput bool
+
+	// AssertSemanticEquivalence makes Source parse both its input and
+	// output, and return an error if their syntax trees differ in any way
+	// other than positions, comments, and the rewrites which gofumpt makes
+	// on purpose, like grouping declarations or using "0o" octal literals.
+	// Like VerifyOutput, it is a safety check against bugs in gofumpt.
+	AssertSemanticEquivalence bool
+
+	// AlwaysParenthesizeImports uses the parenthesized form for all import
+	// declarations, even those with a single import, like:
+	//
+	//     import (
+	//         "fmt"
+	//     )
+	//
+	// Cgo imports are left alone, as they may be preceded by a preamble.
+	AlwaysParenthesizeImports bool
+
+	// Simplify applies the simplifications of gofmt's -s flag, such as
+	// turning "s[a:len(s)]" into "s[a:]", before gofumpt's own rules. It
+	// is independent of ExtraRules, so both can be enabled at once.
+	Simplify bool
+
+	// NormalizeTags separates the key:"value" pairs in struct field tags
+	// with a single space, removing any leading or trailing space. Tags
+	// which don't follow the conventional format understood by
+	// reflect.StructTag are left alone.
+	NormalizeTags bool
+
+	// SplitLongLines splits lines which are too long, such as calls with
+	// many arguments, when doing so would make them significantly shorter.
+	// This feature is experimental. It is also enabled when the
+	// GOFUMPT_SPLIT_LONG_LINES environment variable is set to "on".
+	SplitLongLines bool
+
+	// UnifyReceiverNames renames the receivers of methods to the name most
+	// commonly used by the other methods of the same type in the file, so
+	// that "func (self *T)" becomes "func (t *T)" if most methods use "t".
+	// Since this renames identifiers, methods are only changed when the
+	// new name isn't used in them at all.
+	UnifyReceiverNames bool
+
+	// UppercaseHexDigits uppercases the digits of hexadecimal number
+	// literals, so that 0Xffab becomes 0xFFAB, instead of lowercasing the
+	// digits of hexadecimal floats. The prefix and exponent stay lowercase.
+	UppercaseHexDigits bool
+
+	// FlattenElse removes the else block after an if block which ends in a
+	// return, a break, a continue, a goto, or a call to panic, moving the
+	// statements in the else block after the if statement. Since this
+	// changes the scope of declarations, else blocks declaring names which
+	// are used elsewhere in the surrounding block are left alone.
+	FlattenElse bool
+
+	// DeclSpacing selects which adjacent top-level declarations are
+	// separated by an empty line. The default is DeclSpacingMultiline.
+	DeclSpacing DeclSpacing
+
+	// IndentStyle configures the indentation of the output. Go source
+	// files are indented with tabs, so other styles are only meant for
+	// displaying formatted code, such as in documentation.
+	IndentStyle IndentStyle
+
+	// PreserveNonStdImportOrder keeps non-std imports in their original
+	// order, while std imports are still moved to the top and sorted.
+	// Note that gofmt would sort the non-std imports again.
+	PreserveNonStdImportOrder bool
+
+	// LocalPrefixes lists import path prefixes, like "example.com/mymod",
+	// whose imports are moved to a separate group after the std and
+	// third-party imports, like with goimports' -local flag. A prefix
+	// matches whole path elements, so "example.com/mymod" matches itself
+	// and "example.com/mymod/sub", but not "example.com/mymodule".
+	LocalPrefixes []string
+
+	// NormalizeImportComments makes the comment explaining a blank or dot
+	// import a line comment after the import, like:
+	//
+	//     _ "embed" // for go:embed
+	//
+	// A single-line doc comment is moved after the import, and a
+	// single-line block comment becomes a line comment. Imports without a
+	// comment are left alone, unless ImportCommentPlaceholder is set.
+	NormalizeImportComments bool
+
+	// ImportCommentPlaceholder, if set along with NormalizeImportComments,
+	// is added as a line comment to the blank and dot imports which don't
+	// have one, such as "TODO: explain why this import is needed".
+	ImportCommentPlaceholder string
+
+	// DeclHook, if set, is called for each top-level declaration once the
+	// file has been formatted, reporting whether gofumpt changed it.
+	// Declarations which were joined into another one, such as lone var
+	// declarations which are grouped together, are not reported.
+	DeclHook func(decl ast.Decl, changed bool)
+
+	// RequireExportedDocs makes Check report exported top-level
+	// declarations without a doc comment. A doc comment on a group of
+	// declarations covers all of them.
+	RequireExportedDocs bool
+
+	// RuleSeverities overrides the severity of the diagnostics reported by
+	// Check, keyed by rule name. Rules not in the map keep their default
+	// severity.
+	RuleSeverities map[string]Severity
+
+	// DisabledRules turns off individual formatting rules by name, so that
+	// the rest of the rules can still be applied. The names are stable:
+	//
+	//     import-merge        merge multiple import declarations into one
+	//     decl-group          group adjacent lone var/const/type/import declarations
+	//     decl-spacing        separate multi-line top-level declarations
+	//     comment-spacing     add a space after "//" in comments
+	//     block-comments      add spaces inside single-line "/* */" comments
+	//     short-decl          use := instead of var declarations in funcs
+	//     std-imports         move std imports to the top of an import block
+	//     var-parens          remove parentheses from single var declarations
+	//     empty-block         remove newlines from empty blocks
+	//     block-empty-lines   remove empty lines at the start and end of blocks
+	//     stmt-semicolons     split statements separated by semicolons
+	//     empty-stmts         remove empty statements, like stray semicolons
+	//     err-check-spacing   remove empty lines before "if err != nil"
+	//     label-empty-lines   remove empty lines after labels
+	//     infinite-loop       rewrite "for true {" as "for {"
+	//     empty-else          remove empty else blocks
+	//     redundant-parens    remove parentheses around return values and conditions
+	//     short-case          join multi-line case clauses if short
+	//     empty-field-list    remove newlines from empty field lists
+	//     number-literals     lowercase number literal prefixes and exponents,
+	//                         and drop the "+" sign of exponents
+	//     octal-literals      use the 0o prefix for octal integers
+	//     hex-floats          lowercase hexadecimal floats
+	//     any-interface       use any for the empty interface
+	//     composite-newlines  add newlines around and between composite literal elements
+	//     key-value-comments  move comments after a key's colon past its value
+	//
+	// Rules which are only applied when enabled via other options can be
+	// disabled too:
+	//
+	//     init-spacing         separate init funcs, via SeparateInitFuncs
+	//     func-spacing         separate adjacent funcs, via SeparateFuncDecls
+	//     doc-comment-attach   attach comments to declarations, via ExtraRules
+	//     merge-params         merge adjacent parameters, via ExtraRules
+	//     double-conversions   remove repeated conversions, via ExtraRules
+	//     collapse-interfaces  join short interfaces, via CollapseShortInterfaces
+	//     collapse-calls       join short calls, via CollapseShortCalls
+	//     collapse-typeparams  join short type parameter lists, via CollapseShortTypeParams
+	//     trailing-commas      end split element lists with a comma, via TrailingCommas
+	//     raw-strings          format raw strings, via RawStringFormatters
+	//     long-lines           split long lines, via SplitLongLines
+	//     import-parens        parenthesize imports, via AlwaysParenthesizeImports
+	//     struct-tags          normalize struct tag spacing, via NormalizeTags
+	//     receiver-names       unify receiver names, via UnifyReceiverNames
+	//     flatten-else         remove else blocks after terminating ifs, via FlattenElse
+	//     hex-integers         uppercase hexadecimal integer digits, via UppercaseHexDigits
+	//     local-imports        group local imports last, via LocalPrefixes
+	//     import-comments      normalize blank import comments, via NormalizeImportComments
+	//     simplify             apply gofmt's simplifications, via Simplify
+	//
+	// Unknown names are ignored.
+	DisabledRules []string
+}
+
+// IndentStyle is the indentation used by Source. The zero value indents with
+// tabs, like gofmt.
+type IndentStyle struct {
+	// Spaces, if positive, is the number of spaces to indent with per
+	// level instead of tabs.
+	Spaces int
 }
 
+// DeclSpacing is a policy for separating adjacent top-level declarations with
+// empty lines, used by Options.DeclSpacing. Funcs and methods may also be
+// separated via SeparateFuncDecls and SeparateInitFuncs.
+type DeclSpacing int
+
+const (
+	// DeclSpacingMultiline separates declarations which span multiple
+	// lines from each other, as well as their doc comments.
+	DeclSpacingMultiline DeclSpacing = iota
+
+	// DeclSpacingAll separates all declarations, even single-line ones.
+	// Declarations grouped with parentheses are a single declaration.
+	DeclSpacingAll
+
+	// DeclSpacingNone doesn't add any empty lines between declarations,
+	// but it keeps the existing ones.
+	DeclSpacingNone
+)
+
 // Source formats src in gofumpt's format, assuming that src holds a valid Go
 // source file.
 func Source(src []byte, opts Options) ([]byte, error) {
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	var buf bytes.Buffer
+	return source(token.NewFileSet(), "", src, opts, &buf, nil)
+}
+
+// SourceFile is like Source, but it also takes the name of the file holding
+// src, which is used for positions such as those in parse errors. The file
+// isn't read.
+func SourceFile(filename string, src []byte, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	return source(token.NewFileSet(), filename, src, opts, &buf, nil)
+}
+
+// Change is a change made by SourceWithReport.
+type Change struct {
+	// Pos is the position of the change in the original source.
+	Pos token.Position
+
+	// Rule is the name of the rule which made the change, as listed in
+	// Options.DisabledRules.
+	Rule string
+
+	Message string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s", c.Pos, c.Message)
+}
+
+// ruleMessages holds the messages used for each rule in a Change.
+var ruleMessages = map[string]string{
+	"import-merge":        "multiple import declarations should be merged into one",
+	"decl-group":          "adjacent lone declarations should be grouped",
+	"decl-spacing":        "multi-line top-level declarations should be separated by an empty line",
+	"func-spacing":        "func declarations should be separated by an empty line",
+	"comment-spacing":     "comments should have a space after //",
+	"block-comments":      "single-line block comments should have spaces inside /* */",
+	"short-decl":          "short variable declarations should use :=",
+	"std-imports":         "std imports should be in a separate group at the top",
+	"var-parens":          "single var declarations shouldn't be grouped with parentheses",
+	"empty-block":         "empty blocks shouldn't contain newlines",
+	"block-empty-lines":   "blocks shouldn't start or end with empty lines",
+	"stmt-semicolons":     "statements should be on separate lines",
+	"empty-stmts":         "empty statements should be removed",
+	"err-check-spacing":   "error checks shouldn't be separated from the assignment",
+	"label-empty-lines":   "labels shouldn't be followed by empty lines",
+	"infinite-loop":       "infinite loops shouldn't have a condition",
+	"empty-else":          "empty else blocks should be removed",
+	"redundant-parens":    "return values and conditions shouldn't be parenthesized",
+	"short-case":          "short case clauses should be on a single line",
+	"empty-field-list":    "empty field lists shouldn't contain newlines",
+	"number-literals":     "number literal prefixes and exponents should be lowercase",
+	"octal-literals":      "octal integers should use the 0o prefix",
+	"hex-integers":        "hexadecimal integer digits should be uppercase",
+	"hex-floats":          "hexadecimal floats should have consistent case",
+	"any-interface":       "empty interfaces should be written as any",
+	"composite-newlines":  "composite literal elements should be split consistently",
+	"key-value-comments":  "comments after a key should follow its value",
+	"init-spacing":        "init funcs should be separated by an empty line",
+	"doc-comment-attach":  "doc comments should directly precede their declaration",
+	"merge-params":        "adjacent parameters of the same type should be merged",
+	"double-conversions":  "repeated conversions to the same type should be removed",
+	"collapse-interfaces": "short interfaces should be on a single line",
+	"collapse-calls":      "short calls should be on a single line",
+	"collapse-typeparams": "short type parameter lists should be on a single line",
+	"trailing-commas":     "split element lists should end with a trailing comma",
+	"flatten-else":        "else blocks after a terminating if block should be flattened",
+	"raw-strings":         "raw strings should be formatted",
+	"long-lines":          "long lines should be split",
+	"import-parens":       "import declarations should use parentheses",
+	"struct-tags":         "struct tag pairs should be separated by a single space",
+	"receiver-names":      "receivers should be named like in the other methods of the type",
+	"local-imports":       "local imports should be in a separate group at the bottom",
+	"import-comments":     "blank and dot imports should be explained by a line comment",
+	"simplify":            "code should be simplified like with gofmt -s",
+}
+
+// SourceWithReport is like Source, but it also returns the changes it made,
+// sorted by position. Changes which go/format would make on its own, such as
+// fixing indentation, are not included.
+func SourceWithReport(src []byte, opts Options) ([]byte, []Change, error) {
+	var buf bytes.Buffer
+	var changes []Change
+	res, err := source(token.NewFileSet(), "", src, opts, &buf, &changes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, changes, nil
+}
+
+// source implements Source, printing into buf. Note that the result may share
+// memory with buf. If report is not nil, the changes made are stored in it.
+func source(fset *token.FileSet, filename string, src []byte, opts Options, buf *bytes.Buffer, report *[]Change) ([]byte, error) {
+	if opts.MaxInputBytes > 0 && len(src) > opts.MaxInputBytes {
+		return nil, fmt.Errorf("input is %d bytes, larger than the limit of %d bytes",
+			len(src), opts.MaxInputBytes)
+	}
+	if opts.DetectLangVersion && opts.LangVersion == "" && filename != "" {
+		version, err := DetectLangVersion(filepath.Dir(filename))
+		if err != nil {
+			return nil, err
+		}
+		opts.LangVersion = version
+	}
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
 	if err != nil {
 		return nil, err
 	}
 
-	File(fset, file, opts)
+	changes := formatFile(fset, file, opts, report != nil)
+	if report != nil {
+		// Positions are reported in the original source, as the
+		// line table has been modified.
+		tf := token.NewFileSet().AddFile(filename, -1, len(src))
+		tf.SetLinesForContent(src)
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].offset < changes[j].offset
+		})
+		for _, change := range changes {
+			*report = append(*report, Change{
+				Pos:     tf.Position(tf.Pos(change.offset)),
+				Rule:    change.rule,
+				Message: ruleMessages[change.rule],
+			})
+		}
+	}
+	if testHookFile != nil {
+		testHookFile(file)
+	}
 
-	var buf bytes.Buffer
-	if err := format.Node(&buf, fset, file); err != nil {
+	res, err := printFile(fset, file, opts, buf)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AssertSemanticEquivalence {
+		if err := checkEquivalent(filename, src, res, opts); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// printFile prints a file formatted by File into buf, following the printing
+// options in opts. Note that the result may share memory with buf.
+func printFile(fset *token.FileSet, file *ast.File, opts Options, buf *bytes.Buffer) ([]byte, error) {
+	if opts.IndentStyle.Spaces > 0 || opts.PreserveNonStdImportOrder {
+		// Like go/format, which we can't use as it always sorts
+		// imports and indents with tabs. Note that we already
+		// normalize number literals.
+		cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+		if opts.IndentStyle.Spaces > 0 {
+			cfg = printer.Config{Mode: printer.UseSpaces, Tabwidth: opts.IndentStyle.Spaces}
+		}
+		if !opts.PreserveNonStdImportOrder {
+			ast.SortImports(fset, file)
+		}
+		if err := cfg.Fprint(buf, fset, file); err != nil {
+			return nil, err
+		}
+	} else if err := format.Node(buf, fset, file); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	res := buf.Bytes()
+	if opts.StampHeader {
+		res = stampHeader(res)
+	}
+	if opts.VerifyOutput {
+		if _, err := parser.ParseFile(token.NewFileSet(), fset.File(file.Pos()).Name(), res, 0); err != nil {
+			return nil, fmt.Errorf("formatting produced invalid Go: %w", err)
+		}
+	}
+	return res, nil
 }
 
+// testHookFile, if set, is called on each file after it's been formatted by
+// Source. Tests use it to simulate rules which produce invalid code.
+var testHookFile func(*ast.File)
+
 // File modifies a file and fset in place to follow gofumpt's format. The
 // changes might include manipulating adding or removing newlines in fset,
 // modifying the position of nodes, or modifying literal values.
 func File(fset *token.FileSet, file *ast.File, opts Options) {
+	formatFile(fset, file, opts, false)
+}
+
+// formatFile implements File. If report is true, it returns the changes made.
+func formatFile(fset *token.FileSet, file *ast.File, opts Options, report bool) []change {
+	if os.Getenv("GOFUMPT_SPLIT_LONG_LINES") == "on" {
+		// Kept for backwards compatibility, such as for the gofumpt
+		// command, which has no flag for it.
+		opts.SplitLongLines = true
+	}
 	if opts.LangVersion == "" {
 		opts.LangVersion = "v1"
 	} else if opts.LangVersion[0] != 'v' {
@@ -81,14 +523,51 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 		Options: opts,
 
 		minSplitFactor: 0.4,
+		report:         report,
+	}
+	for _, rule := range opts.DisabledRules {
+		if f.disabled == nil {
+			f.disabled = make(map[string]bool)
+		}
+		f.disabled[rule] = true
+	}
+	var before map[ast.Decl]string
+	if opts.DeclHook != nil {
+		before = make(map[ast.Decl]string, len(file.Decls))
+		for _, decl := range file.Decls {
+			before[decl] = f.printDecl(decl)
+		}
+	}
+	if opts.Simplify && f.enabled("simplify") {
+		simplify(file, f.record)
+	}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			f.hasIgnore = f.hasIgnore || isIgnoreDirective(comment)
+		}
+	}
+	if semver.Compare(opts.LangVersion, "v1.18") >= 0 {
+		f.declaresAny = declaresAny(file)
 	}
 	var topFuncType *ast.FuncType
 	pre := func(c *astutil.Cursor) bool {
+		if f.ignored(c.Node()) {
+			// Neither the node nor its children are formatted, and
+			// post isn't called for it either.
+			return false
+		}
 		f.applyPre(c)
 		switch node := c.Node().(type) {
 		case *ast.FuncDecl:
 			topFuncType = node.Type
 		case *ast.FieldList:
+			if ts, _ := c.Parent().(*ast.TypeSpec); ts != nil && ts.TypeParams == node {
+				// Like the type parameters of funcs, below.
+				// This is reset once we leave the list, so that
+				// the type itself is unaffected.
+				f.minSplitFactor = 0.6
+				break
+			}
 			ft, _ := c.Parent().(*ast.FuncType)
 			if ft == nil || ft != topFuncType {
 				break
@@ -101,7 +580,8 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 			//
 			// We don't just increase longLineLimit,
 			// as we still want splits at around the same place.
-			if ft.Params == node {
+			// Type parameters come first, and are treated the same.
+			if ft.TypeParams == node || ft.Params == node {
 				f.minSplitFactor = 0.6
 			}
 
@@ -127,12 +607,34 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 			if node == topFuncType {
 				f.minSplitFactor = 0.4
 			}
+		case *ast.FieldList:
+			if ts, _ := c.Parent().(*ast.TypeSpec); ts != nil && ts.TypeParams == node {
+				f.minSplitFactor = 0.4
+			}
 		case *ast.BlockStmt:
 			f.blockLevel--
 		}
 		return true
 	}
 	astutil.Apply(file, pre, post)
+
+	if opts.DeclHook != nil {
+		for _, decl := range file.Decls {
+			old, ok := before[decl]
+			opts.DeclHook(decl, !ok || f.printDecl(decl) != old)
+		}
+	}
+	return f.changes
+}
+
+// printDecl prints a top-level declaration along with its comments.
+func (f *fumpter) printDecl(decl ast.Decl) string {
+	var buf bytes.Buffer
+	node := &printer.CommentedNode{Node: decl, Comments: f.astFile.Comments}
+	if err := format.Node(&buf, f.fset, node); err != nil {
+		panic(fmt.Sprintf("unexpected print error: %v", err))
+	}
+	return buf.String()
 }
 
 // Multiline nodes which could easily fit on a single line under this many bytes
@@ -143,7 +645,15 @@ const shortLineLimit = 60
 // into two lines of at least its minSplitFactor factor, may be split.
 const longLineLimit = 100
 
-var rxOctalInteger = regexp.MustCompile(`\A0[0-7_]+\z`)
+// Field lists with a single field may only be kept on a single line by
+// go/printer if the field takes at most this many bytes.
+const oneLineFieldListLimit = 30
+
+var (
+	rxOctalInteger = regexp.MustCompile(`\A0[0-7_]+\z`)
+	rxHexInteger   = regexp.MustCompile(`\A0[xX][0-9a-fA-F_]+\z`)
+	rxHexFloat     = regexp.MustCompile(`\A0[xX][0-9a-fA-F_.]*[pP]`)
+)
 
 type fumpter struct {
 	Options
@@ -159,6 +669,75 @@ type fumpter struct {
 	blockLevel int
 
 	minSplitFactor float64
+
+	// noSplit holds the nodes whose children shouldn't be split onto
+	// separate lines by splitLongLine.
+	noSplit map[ast.Node]bool
+
+	// disabled holds the names of the rules in DisabledRules.
+	disabled map[string]bool
+
+	// rule is the name of the rule making changes, as set by enabled.
+	rule string
+
+	// If report is true, changes holds the changes made so far.
+	report  bool
+	changes []change
+
+	// hasIgnore is whether the file contains any "//gofumpt:ignore"
+	// directives, to not look for them before every node otherwise.
+	hasIgnore bool
+
+	// declaresAny is whether the file might declare its own "any", in
+	// which case empty interfaces are left alone.
+	declaresAny bool
+}
+
+// Line is like token.File.Line, but it ignores //line directives, as we work
+// with the actual lines in the source.
+func (f *fumpter) Line(p token.Pos) int {
+	return f.PositionFor(p, false).Line
+}
+
+// Position is like token.File.Position, but it ignores //line directives, like
+// Line.
+func (f *fumpter) Position(p token.Pos) token.Position {
+	return f.PositionFor(p, false)
+}
+
+// change is a Change made by a rule, at an offset in the source.
+type change struct {
+	offset int
+	rule   string
+}
+
+// enabled reports whether a rule isn't turned off via DisabledRules. If so,
+// the changes made from then on are attributed to the rule.
+func (f *fumpter) enabled(rule string) bool {
+	if f.disabled[rule] {
+		return false
+	}
+	f.setRule(rule)
+	return true
+}
+
+// setRule attributes the changes made from then on to a rule, which must
+// already be known to be enabled.
+func (f *fumpter) setRule(rule string) {
+	f.rule = rule
+}
+
+// record notes a change made by the current rule at pos, if we are reporting
+// changes. Changes to the newlines in fset are recorded automatically.
+func (f *fumpter) record(pos token.Pos) {
+	if !f.report {
+		return
+	}
+	c := change{offset: f.Offset(pos), rule: f.rule}
+	if n := len(f.changes); n > 0 && f.changes[n-1] == c {
+		return
+	}
+	f.changes = append(f.changes, c)
 }
 
 func (f *fumpter) commentsBetween(p1, p2 token.Pos) []*ast.CommentGroup {
@@ -195,33 +774,28 @@ func (f *fumpter) inlineComment(pos token.Pos) *ast.Comment {
 func (f *fumpter) addNewline(at token.Pos) {
 	offset := f.Offset(at)
 
-	field := reflect.ValueOf(f.File).Elem().FieldByName("lines")
-	n := field.Len()
-	lines := make([]int, 0, n+1)
-	for i := 0; i < n; i++ {
-		cur := int(field.Index(i).Int())
-		if offset == cur {
-			// This newline already exists; do nothing. Duplicate
-			// newlines can't exist.
-			return
-		}
-		if offset >= 0 && offset < cur {
-			lines = append(lines, offset)
-			offset = -1
-		}
-		lines = append(lines, cur)
-	}
-	if offset >= 0 {
-		lines = append(lines, offset)
+	lines := f.Lines()
+	i := sort.SearchInts(lines, offset)
+	if i < len(lines) && lines[i] == offset {
+		// This newline already exists; do nothing. Duplicate
+		// newlines can't exist.
+		return
 	}
+	lines = append(lines, 0)
+	copy(lines[i+1:], lines[i:])
+	lines[i] = offset
 	if !f.SetLines(lines) {
 		panic(fmt.Sprintf("could not set lines to %v", lines))
 	}
+	f.record(at)
 }
 
 // removeLines removes all newlines between two positions, so that they end
 // up on the same line.
 func (f *fumpter) removeLines(fromLine, toLine int) {
+	if fromLine < toLine {
+		f.record(f.LineStart(fromLine + 1))
+	}
 	for fromLine < toLine {
 		f.MergeLine(fromLine)
 		toLine--
@@ -230,8 +804,12 @@ func (f *fumpter) removeLines(fromLine, toLine int) {
 
 // removeLinesBetween is like removeLines, but it leaves one newline between the
 // two positions.
+//
+// The lines are merged into the line holding from, so that the line holding to
+// keeps its columns. Otherwise, a //line directive at the start of that line
+// would be indented and no longer apply.
 func (f *fumpter) removeLinesBetween(from, to token.Pos) {
-	f.removeLines(f.Line(from)+1, f.Line(to))
+	f.removeLines(f.Line(from), f.Line(to)-1)
 }
 
 type byteCounter int
@@ -296,19 +874,91 @@ func (f *fumpter) lineEnd(line int) token.Pos {
 // "go:generate", to prevent matching false positives like "https://site".
 var rxCommentDirective = regexp.MustCompile(`^([a-z-]+:[a-z]+|line\b|export\b|extern\b|sys(nb)?\b|nolint\b)`)
 
+// spaceBlockComment makes a single-line /*-style comment have one space after
+// "/*" and before "*/", like "/* unused */". Directives are left alone, as well
+// as comments which could be code or decorations, like "/*****/" or "/*-x-*/".
+func (f *fumpter) spaceBlockComment(comment *ast.Comment) {
+	if !strings.HasPrefix(comment.Text, "/*") || strings.Contains(comment.Text, "\n") {
+		return // a //-style or multi-line comment
+	}
+	body := strings.TrimSpace(comment.Text[2 : len(comment.Text)-2])
+	if body == "" || rxCommentDirective.MatchString(body) || f.rawStringFormatter(comment) != nil {
+		return
+	}
+	first, _ := utf8.DecodeRuneInString(body)
+	last, _ := utf8.DecodeLastRuneInString(body)
+	if !unicode.IsLetter(first) && !unicode.IsNumber(first) {
+		return
+	}
+	if !unicode.IsLetter(last) && !unicode.IsNumber(last) && !strings.ContainsRune(".,;:!?)'\"", last) {
+		return
+	}
+	if text := "/* " + body + " */"; text != comment.Text {
+		f.record(comment.Pos())
+		comment.Text = text
+	}
+}
+
+// isIgnoreDirective reports whether comment is a "//gofumpt:ignore" directive,
+// which may be followed by an explanation.
+func isIgnoreDirective(comment *ast.Comment) bool {
+	body := strings.TrimPrefix(comment.Text, "//gofumpt:ignore")
+	return body != comment.Text && (body == "" || body[0] == ' ')
+}
+
+// ignored reports whether node is a declaration, spec, or statement directly
+// preceded by a "//gofumpt:ignore" directive, in which case none of our rules
+// should be applied to it or its children.
+func (f *fumpter) ignored(node ast.Node) bool {
+	if !f.hasIgnore {
+		return false
+	}
+	switch node.(type) {
+	case ast.Decl, ast.Spec, ast.Stmt:
+	default:
+		return false
+	}
+	comments := f.astFile.Comments
+	i := sort.Search(len(comments), func(i int) bool {
+		return comments[i].Pos() >= node.Pos()
+	})
+	if i == 0 {
+		return false
+	}
+	group := comments[i-1]
+	if f.Line(group.End()) != f.Line(node.Pos())-1 {
+		return false // not directly preceding the node
+	}
+	for _, comment := range group.List {
+		if isIgnoreDirective(comment) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *fumpter) applyPre(c *astutil.Cursor) {
 	f.splitLongLine(c)
 
 	switch node := c.Node().(type) {
 	case *ast.File:
-		// Join contiguous lone var/const/import lines.
+		// Parenthesize imports before merging them, as parenthesized
+		// imports are merged.
+		if f.AlwaysParenthesizeImports && f.enabled("import-parens") {
+			f.parenthesizeImports(node)
+		}
+		if f.enabled("import-merge") {
+			f.mergeImportDecls(node)
+		}
+
+		// Join contiguous lone var/const/type/import lines.
 		// Abort if there are empty lines or comments in between,
 		// includng a leading comment, which could be a directive.
 		newDecls := make([]ast.Decl, 0, len(node.Decls))
 		for i := 0; i < len(node.Decls); {
 			newDecls = append(newDecls, node.Decls[i])
 			start, ok := node.Decls[i].(*ast.GenDecl)
-			if !ok || isCgoImport(start) || start.Doc != nil {
+			if !ok || isCgoImport(start) || start.Doc != nil || !f.enabled("decl-group") {
 				i++
 				continue
 			}
@@ -319,6 +969,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 					f.Line(lastPos) < f.Line(cont.Pos())-1 || isCgoImport(cont) {
 					break
 				}
+				f.record(cont.Pos())
 				start.Specs = append(start.Specs, cont.Specs...)
 				if c := f.inlineComment(cont.End()); c != nil {
 					// don't move an inline comment outside
@@ -335,10 +986,12 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		node.Decls = newDecls
 
 		// Multiline top-level declarations should be separated by an
-		// empty line.
+		// empty line, unless DeclSpacing says otherwise.
 		// Do this after the joining of lone declarations above,
 		// as joining single-line declarations makes then multi-line.
-		var lastMulti bool
+		// Init funcs may also be separated, if enabled.
+		// Adjacent funcs and methods may also be separated, if enabled.
+		var lastMulti, lastInit, lastFunc bool
 		var lastEnd token.Pos
 		for _, decl := range node.Decls {
 			pos := decl.Pos()
@@ -348,15 +1001,44 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			}
 
 			multi := f.Line(pos) < f.Line(decl.End())
-			if multi && lastMulti && f.Line(lastEnd)+1 == f.Line(pos) {
+			init := f.SeparateInitFuncs && isInitFunc(decl)
+			_, fn := decl.(*ast.FuncDecl)
+			fn = fn && f.SeparateFuncDecls
+			spaced := f.DeclSpacing == DeclSpacingAll ||
+				(f.DeclSpacing == DeclSpacingMultiline && multi && lastMulti)
+			separate := ((init || lastInit) && f.enabled("init-spacing")) ||
+				(spaced && f.enabled("decl-spacing")) ||
+				(fn && lastFunc && f.enabled("func-spacing"))
+			if separate && lastEnd.IsValid() && f.Line(lastEnd)+1 == f.Line(pos) {
 				f.addNewline(lastEnd)
 			}
 
 			lastMulti = multi
+			lastInit = init
+			lastFunc = fn
 			lastEnd = decl.End()
 		}
 
+		if f.ExtraRules && f.enabled("doc-comment-attach") {
+			f.attachDocComments(node)
+		}
+		if f.UnifyReceiverNames && f.enabled("receiver-names") {
+			for _, pos := range unifyReceiverNames(node) {
+				f.record(pos)
+			}
+		}
+
 		// Comments aren't nodes, so they're not walked by default.
+		if f.enabled("block-comments") {
+			for _, group := range node.Comments {
+				for _, comment := range group.List {
+					f.spaceBlockComment(comment)
+				}
+			}
+		}
+		if !f.enabled("comment-spacing") {
+			break
+		}
 	groupLoop:
 		for _, group := range node.Comments {
 			for _, comment := range group.List {
@@ -369,6 +1051,10 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 					// this line is a directive
 					continue groupLoop
 				}
+				if f.rawStringFormatter(comment) != nil {
+					// this line is a raw string marker
+					continue groupLoop
+				}
 				r, _ := utf8.DecodeRuneInString(body)
 				if !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsSpace(r) {
 					// this line could be code like "//{"
@@ -381,12 +1067,16 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 				body := strings.TrimPrefix(comment.Text, "//")
 				r, _ := utf8.DecodeRuneInString(body)
 				if !unicode.IsSpace(r) {
+					f.record(comment.Pos())
 					comment.Text = "// " + strings.TrimPrefix(comment.Text, "//")
 				}
 			}
 		}
 
 	case *ast.DeclStmt:
+		if !f.enabled("short-decl") {
+			break
+		}
 		decl, ok := node.Decl.(*ast.GenDecl)
 		if !ok || decl.Tok != token.VAR || len(decl.Specs) != 1 {
 			break // e.g. const name = "value"
@@ -403,6 +1093,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 				tok = token.DEFINE
 			}
 		}
+		f.record(node.Pos())
 		c.Replace(&ast.AssignStmt{
 			Lhs: names,
 			Tok: tok,
@@ -410,14 +1101,15 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		})
 
 	case *ast.GenDecl:
-		if node.Tok == token.IMPORT && node.Lparen.IsValid() {
+		if node.Tok == token.IMPORT && node.Lparen.IsValid() &&
+			(f.enabled("std-imports") || f.groupLocalImports()) {
 			f.joinStdImports(node)
 		}
 
 		// Single var declarations shouldn't use parentheses, unless
 		// there's a comment on the grouped declaration.
 		if node.Tok == token.VAR && len(node.Specs) == 1 &&
-			node.Lparen.IsValid() && node.Doc == nil {
+			node.Lparen.IsValid() && node.Doc == nil && f.enabled("var-parens") {
 			specPos := node.Specs[0].Pos()
 			specEnd := node.Specs[0].End()
 
@@ -432,20 +1124,40 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 
 			// Remove the parentheses. go/printer will automatically
 			// get rid of the newlines.
+			f.record(node.Lparen)
 			node.Lparen = token.NoPos
 			node.Rparen = token.NoPos
 		}
 
 	case *ast.BlockStmt:
+		if f.FlattenElse && f.enabled("flatten-else") {
+			var sign *ast.FuncType
+			switch parent := c.Parent().(type) {
+			case *ast.FuncDecl:
+				sign = parent.Type
+			case *ast.FuncLit:
+				sign = parent.Type
+			}
+			node.List = f.flattenElse(node.List, sign)
+		}
+		if f.enabled("empty-stmts") {
+			node.List = f.removeEmptyStmts(node.List, node.Lbrace, node.Rbrace)
+		}
 		f.stmts(node.List)
 		comments := f.commentsBetween(node.Lbrace, node.Rbrace)
 		if len(node.List) == 0 && len(comments) == 0 {
-			f.removeLinesBetween(node.Lbrace, node.Rbrace)
+			if f.enabled("empty-block") {
+				f.removeLinesBetween(node.Lbrace, node.Rbrace)
+			}
+			break
+		}
+		if !f.enabled("block-empty-lines") {
 			break
 		}
 
 		var sign *ast.FuncType
-		var cond ast.Expr
+		var cond ast.Node
+		isSwitch := false
 		switch parent := c.Parent().(type) {
 		case *ast.FuncDecl:
 			sign = parent.Type
@@ -455,13 +1167,16 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			cond = parent.Cond
 		case *ast.ForStmt:
 			cond = parent.Cond
+		case *ast.SwitchStmt:
+			// Like with if statements, the init statement
+			// doesn't matter here; only the tag does.
+			cond = parent.Tag
+			isSwitch = true
+		case *ast.TypeSwitchStmt:
+			cond = parent.Assign
+			isSwitch = true
 		}
 
-		if len(node.List) > 1 && sign == nil {
-			// only if we have a single statement, or if
-			// it's a func body.
-			break
-		}
 		var bodyPos, bodyEnd token.Pos
 
 		if len(node.List) > 0 {
@@ -477,31 +1192,100 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			}
 		}
 
+		// No block should end with empty lines.
 		f.removeLinesBetween(bodyEnd, node.Rbrace)
 
-		if cond != nil && f.Line(cond.Pos()) != f.Line(cond.End()) {
+		if len(node.List) > 1 && sign == nil && !isSwitch {
+			// only remove leading empty lines if we have a
+			// single statement, or if it's a func body, or if
+			// it's a switch body made up of case clauses.
+			break
+		}
+
+		if cond != nil && f.Line(cond.Pos()) != f.Line(cond.End()) &&
+			!f.NoBlankAfterMultilineCondition {
 			// The body is preceded by a multi-line condition, so an
 			// empty line can help readability.
 			return
 		}
-		if sign != nil {
-			var lastParam *ast.Field
-			if l := sign.Results; l != nil && len(l.List) > 0 {
-				lastParam = l.List[len(l.List)-1]
-			} else if l := sign.Params; l != nil && len(l.List) > 0 {
-				lastParam = l.List[len(l.List)-1]
-			}
-			endLine := f.Line(sign.End())
-			if lastParam != nil && f.Line(sign.Pos()) != endLine && f.Line(lastParam.Pos()) == endLine {
-				// The body is preceded by a multi-line function
-				// signature, and the empty line helps readability.
+		if sign != nil && len(comments) > 0 && comments[0].Pos() == bodyPos {
+			// Func bodies lose their leading empty line even after a
+			// multi-line signature, but not if they start with a
+			// directive. Directives like //line and //nolint are
+			// positional, so we leave the lines before a leading
+			// one as written. Note that other blocks, such as
+			// composite literals, make no such exception.
+			body := strings.TrimPrefix(comments[0].List[0].Text, "//")
+			if body != comments[0].List[0].Text && rxCommentDirective.MatchString(body) {
 				return
 			}
 		}
 
 		f.removeLinesBetween(node.Lbrace, bodyPos)
 
+	case *ast.LabeledStmt:
+		if es, ok := node.Stmt.(*ast.EmptyStmt); ok && es.Implicit {
+			// A label at the end of a block, followed by '}'.
+			break
+		}
+		if !f.enabled("label-empty-lines") {
+			break
+		}
+		// Keep any comments between the label and its statement, but
+		// not the empty lines around them.
+		from := node.Colon
+		for _, group := range f.commentsBetween(node.Colon, node.Stmt.Pos()) {
+			f.removeLinesBetween(from, group.Pos())
+			from = group.End()
+		}
+		f.removeLinesBetween(from, node.Stmt.Pos())
+
+	case *ast.ReturnStmt:
+		for i, result := range node.Results {
+			node.Results[i] = f.unparen(result, false)
+		}
+
+	case *ast.ExprStmt:
+		node.X = f.unparen(node.X, false)
+
+	case *ast.IfStmt:
+		node.Cond = f.unparen(node.Cond, true)
+
+	case *ast.SwitchStmt:
+		if node.Tag != nil {
+			node.Tag = f.unparen(node.Tag, true)
+		}
+
+	case *ast.ForStmt:
+		if node.Cond != nil {
+			node.Cond = f.unparen(node.Cond, true)
+		}
+
+		// "for true {" is better written as "for {". Note that go/printer
+		// already prints "for ;; {" as "for {".
+		// An identifier resolved by the parser isn't the builtin true.
+		if f.enabled("infinite-loop") && node.Init == nil && node.Post == nil &&
+			identEqual(node.Cond, "true") &&
+			node.Cond.(*ast.Ident).Obj == nil &&
+			len(f.commentsBetween(node.For, node.Body.Lbrace)) == 0 {
+			f.record(node.Cond.Pos())
+			node.Cond = nil
+		}
+
+		// Splitting the init or post statements of a for loop would
+		// leave the rest of its header at the same indentation as its
+		// body.
+		if node.Init != nil {
+			f.markNoSplit(node.Init)
+		}
+		if node.Post != nil {
+			f.markNoSplit(node.Post)
+		}
+
 	case *ast.CaseClause:
+		if f.enabled("empty-stmts") {
+			node.Body = f.removeEmptyStmts(node.Body, node.Colon, token.NoPos)
+		}
 		f.stmts(node.Body)
 		openLine := f.Line(node.Case)
 		closeLine := f.Line(node.Colon)
@@ -513,81 +1297,610 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			// don't move comments
 			break
 		}
-		if f.printLength(node) > shortLineLimit {
+		if !f.enabled("short-case") || f.printLength(node) > shortLineLimit {
 			// too long to collapse
 			break
 		}
 		f.removeLines(openLine, closeLine)
 
 	case *ast.CommClause:
+		if f.enabled("empty-stmts") {
+			node.Body = f.removeEmptyStmts(node.Body, node.Colon, token.NoPos)
+		}
 		f.stmts(node.Body)
 
+	case *ast.CallExpr:
+		if f.ExtraRules && f.enabled("double-conversions") {
+			f.removeDoubleConversion(node)
+		}
+
+	case *ast.ImportSpec:
+		if f.NormalizeImportComments && f.enabled("import-comments") {
+			f.normalizeImportComment(c.Parent().(*ast.GenDecl), node)
+		}
+
+	case *ast.KeyValueExpr:
+		// go/printer doesn't add a space between the colon and a line
+		// comment after it, nor does it indent the value on the next
+		// line. Move the comment after the value, on the key's line.
+		comments := f.commentsBetween(node.Colon, node.Value.End())
+		if len(comments) != 1 || len(comments[0].List) != 1 {
+			break
+		}
+		comment := comments[0].List[0]
+		colonLine := f.Line(node.Colon)
+		valueLine := f.Line(node.Value.Pos())
+		if !strings.HasPrefix(comment.Text, "//") || f.Line(comment.Pos()) != colonLine ||
+			valueLine != colonLine+1 || f.Line(node.Value.End()) != valueLine {
+			break
+		}
+		if f.inlineComment(node.Value.End()) != nil {
+			// the value has a comment of its own
+			break
+		}
+		if !f.enabled("key-value-comments") {
+			break
+		}
+		f.record(comment.Pos())
+		// Use the position of the value's last character, as the
+		// value's end may be the position of a closing brace.
+		comment.Slash = node.Value.End() - 1
+		f.removeLines(colonLine, valueLine)
+
+	case *ast.Field:
+		if node.Tag != nil && f.NormalizeTags && f.enabled("struct-tags") {
+			f.normalizeTag(node.Tag)
+		}
+
+	case *ast.StructType:
+		// Splitting a single-line struct or interface type, such as an
+		// anonymous struct parameter, would make go/printer put each of
+		// its fields on a separate line.
+		f.markNoSplit(node.Fields)
+
+	case *ast.InterfaceType:
+		// The predeclared "any" was introduced in 1.18.
+		if node.Methods.NumFields() == 0 && semver.Compare(f.LangVersion, "v1.18") >= 0 &&
+			!f.declaresAny && len(f.commentsBetween(node.Pos(), node.End())) == 0 &&
+			f.enabled("any-interface") {
+			f.record(node.Pos())
+			f.removeLines(f.Line(node.Pos()), f.Line(node.End()))
+			c.Replace(&ast.Ident{NamePos: node.Pos(), Name: "any"})
+			break
+		}
+		f.markNoSplit(node.Methods)
+		if f.CollapseShortInterfaces && f.enabled("collapse-interfaces") {
+			f.collapseShortInterface(node)
+		}
+
 	case *ast.FieldList:
-		if node.NumFields() == 0 && f.inlineComment(node.Pos()) == nil {
+		if node.NumFields() == 0 && len(f.commentsBetween(node.Pos(), node.End())) == 0 &&
+			f.enabled("empty-field-list") {
 			// Empty field lists should not contain a newline.
-			// Do not join the two lines if the first has an inline
-			// comment, as that can result in broken formatting.
+			// Do not join the lines if there are any comments, as
+			// that can result in broken formatting, such as when
+			// a line comment ends up right after the opening
+			// parenthesis.
 			openLine := f.Line(node.Pos())
 			closeLine := f.Line(node.End())
 			f.removeLines(openLine, closeLine)
 		}
 
 		// Merging adjacent fields (e.g. parameters) is disabled by default.
-		if !f.ExtraRules {
+		if !f.ExtraRules || !f.enabled("merge-params") {
 			break
 		}
 		switch c.Parent().(type) {
-		case *ast.FuncDecl, *ast.FuncType, *ast.InterfaceType:
-			node.List = f.mergeAdjacentFields(node.List)
+		case *ast.FuncDecl, *ast.FuncType, *ast.InterfaceType, *ast.TypeSpec:
+			// This includes type parameters, whose types are
+			// their constraints. Like elsewhere, results are
+			// never split into multiple lines.
+			ft, _ := c.Parent().(*ast.FuncType)
+			split := ft == nil || ft.Results != node
+			node.List = f.mergeAdjacentFields(node.List, split)
 			c.Replace(node)
 		case *ast.StructType:
 			// Do not merge adjacent fields in structs.
 		}
 
 	case *ast.BasicLit:
-		// Octal number literals were introduced in 1.13.
+		if node.Kind == token.STRING && len(f.RawStringFormatters) > 0 &&
+			f.enabled("raw-strings") {
+			f.formatRawString(node)
+			break
+		}
+		if node.Kind != token.INT && node.Kind != token.FLOAT && node.Kind != token.IMAG {
+			break // not a number
+		}
+		if value := normalizeNumber(node.Value); value != node.Value && f.enabled("number-literals") {
+			f.record(node.Pos())
+			node.Value = value
+			c.Replace(node)
+		}
+		if f.UppercaseHexDigits && node.Kind == token.INT &&
+			rxHexInteger.MatchString(node.Value) && f.enabled("hex-integers") {
+			if value := upperHexDigits(node.Value); value != node.Value {
+				f.record(node.Pos())
+				node.Value = value
+				c.Replace(node)
+			}
+		}
+		// Octal number literals and hexadecimal floats were introduced in 1.13.
 		if semver.Compare(f.LangVersion, "v1.13") >= 0 {
-			if node.Kind == token.INT && rxOctalInteger.MatchString(node.Value) {
+			if node.Kind == token.INT && rxOctalInteger.MatchString(node.Value) &&
+				f.enabled("octal-literals") {
+				f.record(node.Pos())
 				node.Value = "0o" + node.Value[1:]
 				c.Replace(node)
 			}
+			if node.Kind != token.INT && rxHexFloat.MatchString(node.Value) &&
+				f.enabled("hex-floats") {
+				value := strings.ToLower(node.Value)
+				if f.UppercaseHexDigits {
+					value = upperHexDigits(node.Value)
+				}
+				if value != node.Value {
+					f.record(node.Pos())
+					node.Value = value
+					c.Replace(node)
+				}
+			}
 		}
 	}
 }
 
-func (f *fumpter) applyPost(c *astutil.Cursor) {
-	switch node := c.Node().(type) {
-	// Adding newlines to composite literals happens as a "post" step, so
-	// that we can take into account whether "pre" steps added any newlines
-	// that would affect us here.
-	case *ast.CompositeLit:
-		if len(node.Elts) == 0 {
-			// doesn't have elements
+// unparen returns expr without the parentheses around it, for a position where
+// they are never needed, such as a return value. The condition of an if, for,
+// or switch statement may need them around a composite literal, as in
+// "if (T{}).ok {", so those are left alone.
+func (f *fumpter) unparen(expr ast.Expr, cond bool) ast.Expr {
+	paren, ok := expr.(*ast.ParenExpr)
+	if !ok || len(f.commentsBetween(paren.Lparen, paren.Rparen)) > 0 ||
+		!f.enabled("redundant-parens") {
+		return expr
+	}
+	inner := paren.X
+	for {
+		paren, ok := inner.(*ast.ParenExpr)
+		if !ok {
 			break
 		}
-		openLine := f.Line(node.Lbrace)
-		closeLine := f.Line(node.Rbrace)
-		if openLine == closeLine {
-			// all in a single line
-			break
+		inner = paren.X
+	}
+	if cond && containsCompositeLit(inner) {
+		return expr
+	}
+	f.record(paren.Pos())
+	return inner
+}
+
+// containsCompositeLit reports whether node contains a composite literal,
+// including within func literals.
+func containsCompositeLit(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		if _, ok := node.(*ast.CompositeLit); ok {
+			found = true
 		}
+		return !found
+	})
+	return found
+}
 
-		newlineAroundElems := false
-		newlineBetweenElems := false
-		lastLine := openLine
-		for i, elem := range node.Elts {
-			if elPos := f.Line(elem.Pos()); elPos > lastLine {
-				if i == 0 {
-					newlineAroundElems = true
+// predeclaredTypes holds the names of the predeclared types which can be used in
+// conversions.
+var predeclaredTypes = map[string]bool{
+	"any": true, "bool": true, "byte": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true, "int8": true,
+	"int16": true, "int32": true, "int64": true, "rune": true, "string": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"uintptr": true,
+}
 
-					// rm leading lines if they exist
-					f.removeLines(openLine+1, elPos)
-				} else {
-					newlineBetweenElems = true
-				}
-			}
-			lastLine = f.Line(elem.End())
-		}
+// removeDoubleConversion turns a repeated conversion like "int(int(x))" into
+// "int(x)". Without type information, we can't tell conversions apart from
+// func calls like "f(f(x))", so only predeclared types are considered.
+func (f *fumpter) removeDoubleConversion(call *ast.CallExpr) {
+	outer, ok := call.Fun.(*ast.Ident)
+	if !ok || !predeclaredTypes[outer.Name] || outer.Obj != nil ||
+		len(call.Args) != 1 || call.Ellipsis.IsValid() {
+		return
+	}
+	for {
+		inner, ok := call.Args[0].(*ast.CallExpr)
+		if !ok || !identEqual(inner.Fun, outer.Name) || inner.Fun.(*ast.Ident).Obj != nil ||
+			len(inner.Args) != 1 || inner.Ellipsis.IsValid() {
+			return
+		}
+		f.record(inner.Pos())
+		call.Args[0] = inner.Args[0]
+	}
+}
+
+// normalizeTag separates the key:"value" pairs in a struct tag with a single
+// space. The literal keeps its quoting, be it a raw or an interpreted string.
+func (f *fumpter) normalizeTag(lit *ast.BasicLit) {
+	tag, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+	norm, ok := normalizeTag(tag)
+	if !ok || norm == tag {
+		return
+	}
+	f.record(lit.Pos())
+	if lit.Value[0] == '`' {
+		lit.Value = "`" + norm + "`"
+	} else {
+		lit.Value = strconv.Quote(norm)
+	}
+}
+
+// normalizeTag returns tag with its key:"value" pairs separated by a single
+// space. It reports false if tag doesn't follow the format understood by
+// reflect.StructTag, in which case it shouldn't be modified.
+func normalizeTag(tag string) (string, bool) {
+	var pairs []string
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			break
+		}
+		// Like reflect.StructTag.Lookup, a key is a non-empty sequence of
+		// non-control characters other than space, quote, and colon.
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7F {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return "", false
+		}
+		// The value is a quoted string, which ends at the first unescaped
+		// quote.
+		j := i + 2
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			return "", false
+		}
+		pair := tag[:j+1]
+		if _, err := strconv.Unquote(pair[i+1:]); err != nil {
+			return "", false
+		}
+		pairs = append(pairs, pair)
+		tag = tag[j+1:]
+		if tag != "" && tag[0] != ' ' {
+			return "", false // pairs must be separated by spaces
+		}
+	}
+	return strings.Join(pairs, " "), true
+}
+
+// formatRawString formats the contents of a raw string literal with one of
+// RawStringFormatters, if it's preceded by a marker comment like "//sql:" on
+// the same line or the line before.
+func (f *fumpter) formatRawString(lit *ast.BasicLit) {
+	if lit.Value[0] != '`' {
+		return // not a raw string
+	}
+	comments := f.astFile.Comments
+	i := sort.Search(len(comments), func(i int) bool {
+		return comments[i].Pos() >= lit.Pos()
+	})
+	if i == 0 {
+		return
+	}
+	marker := comments[i-1].List[len(comments[i-1].List)-1]
+	if f.Line(lit.Pos())-f.Line(marker.End()) > 1 {
+		return // not directly preceding the literal
+	}
+	fn := f.rawStringFormatter(marker)
+	if fn == nil {
+		return
+	}
+	content := fn(lit.Value[1 : len(lit.Value)-1])
+	if strings.Contains(content, "`") {
+		return // can't be a raw string
+	}
+
+	// go/printer keeps track of lines as it prints the literal, so the
+	// lines it spans in the file must match the new number of newlines.
+	// Otherwise, the empty lines after the literal could be lost.
+	startLine := f.Line(lit.Pos())
+	extra := strings.Count(content, "\n") - (f.Line(lit.End()) - startLine)
+	if extra < 0 {
+		f.removeLines(startLine, startLine-extra)
+	}
+	var newlines []token.Pos
+	for pos := lit.Pos() + 1; pos < lit.End() && len(newlines) < extra; pos++ {
+		if f.Position(pos).Column > 1 {
+			newlines = append(newlines, pos)
+		}
+	}
+	if len(newlines) < extra {
+		return // not enough room for the new lines
+	}
+	f.record(lit.Pos())
+	for _, pos := range newlines {
+		f.addNewline(pos)
+	}
+	lit.Value = "`" + content + "`"
+}
+
+// rawStringFormatter returns the formatter in RawStringFormatters for a marker
+// comment like "//sql:", or nil if the comment isn't such a marker.
+func (f *fumpter) rawStringFormatter(comment *ast.Comment) func(string) string {
+	name := strings.TrimPrefix(comment.Text, "//")
+	if name == comment.Text || !strings.HasSuffix(name, ":") {
+		return nil
+	}
+	return f.RawStringFormatters[name[:len(name)-1]]
+}
+
+// normalizeNumber returns the canonical form of a number literal, which uses
+// lowercase base prefixes and exponents. Integer imaginary literals also lose
+// their leading zeros, as they are always decimal.
+//
+// go/format already does this when printing, but doing it on the AST means the
+// canonical form is kept when File is used with other printers.
+func normalizeNumber(value string) string {
+	if len(value) < 2 {
+		return value // a single digit
+	}
+	// Note that the kind doesn't matter, as imaginary literals can hold
+	// any integer or float value.
+	switch prefix := strings.ToLower(value[:2]); prefix {
+	case "0x":
+		// Hexadecimal digits may contain "E", so only the "p"
+		// exponent of hexadecimal floats is lowercased.
+		value = prefix + strings.Replace(value[2:], "P", "p", 1)
+		value = strings.Replace(value, "p+", "p", 1)
+	case "0o", "0b":
+		value = prefix + value[2:]
+	default:
+		value = strings.Replace(value, "E", "e", 1)
+		value = strings.Replace(value, "e+", "e", 1)
+		imag := strings.HasSuffix(value, "i")
+		if imag && !strings.ContainsAny(value, ".e") {
+			value = strings.TrimLeft(value, "0_")
+			if value == "i" {
+				value = "0i"
+			}
+		}
+	}
+	return value
+}
+
+// upperHexDigits uppercases the digits of a hexadecimal number literal. Only
+// the letters a to f are changed, so the "0x" prefix, the "p" exponent, and the
+// "i" imaginary suffix are left alone.
+func upperHexDigits(value string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'f' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, value)
+}
+
+func (f *fumpter) markNoSplit(node ast.Node) {
+	if f.noSplit == nil {
+		f.noSplit = make(map[ast.Node]bool)
+	}
+	f.noSplit[node] = true
+}
+
+// collapseShortInterface joins an interface type with a single method onto a
+// single line, like "interface{ Close() error }". Interfaces with embedded
+// types or type elements, such as constraints, are left alone.
+func (f *fumpter) collapseShortInterface(node *ast.InterfaceType) {
+	list := node.Methods
+	if len(list.List) != 1 || len(list.List[0].Names) != 1 {
+		return
+	}
+	method := list.List[0]
+	openLine := f.Line(list.Opening)
+	closeLine := f.Line(list.Closing)
+	if openLine == closeLine {
+		// nothing to do
+		return
+	}
+	if f.Line(method.Pos()) != f.Line(method.End()) {
+		// the method itself is multi-line
+		return
+	}
+	if len(f.commentsBetween(list.Opening, list.Closing)) > 0 ||
+		f.inlineComment(list.Closing) != nil {
+		// don't move comments
+		return
+	}
+	// go/printer only keeps a field list on a single line if it's small
+	// enough, which is a stricter limit than shortLineLimit. Mimic how it
+	// measures a method: one byte for the name, plus its func type.
+	var size byteCounter
+	if err := format.Node(&size, f.fset, method.Type); err != nil {
+		panic(fmt.Sprintf("unexpected print error: %v", err))
+	}
+	if 1+int(size) > oneLineFieldListLimit {
+		// too long to collapse
+		return
+	}
+	f.removeLines(openLine, closeLine)
+}
+
+// collapseShortTypeParams joins a type parameter list with each parameter on a
+// separate line onto a single line, like "[K comparable, V any]".
+func (f *fumpter) collapseShortTypeParams(list *ast.FieldList) {
+	openLine := f.Line(list.Opening)
+	closeLine := f.Line(list.Closing)
+	if openLine == closeLine {
+		// nothing to do
+		return
+	}
+	// Like in collapseShortCall, only count the indentation once.
+	length := len("[]") + f.blockLevel*8
+	lastLine := openLine
+	for _, field := range list.List {
+		if f.Line(field.Pos()) != f.Line(field.End()) {
+			// the parameter is multi-line, such as a constraint
+			// with methods
+			return
+		}
+		if f.Line(field.Pos()) == lastLine {
+			// not one parameter per line
+			return
+		}
+		lastLine = f.Line(field.End())
+		// go/printer can't print a field on its own, but since it is on
+		// a single line, its columns give us its length.
+		length += f.Position(field.End()).Column - f.Position(field.Pos()).Column
+	}
+	if len(f.commentsBetween(list.Opening, list.Closing)) > 0 {
+		// don't move comments
+		return
+	}
+	length += len(", ") * (len(list.List) - 1)
+	if length > shortLineLimit {
+		// too long to collapse
+		return
+	}
+	f.removeLines(openLine, closeLine)
+}
+
+// needsTrailingComma reports whether a list of elements between the open and
+// close positions, such as the arguments of a call, is split across lines but
+// has its last element on the closing line. A newline before the closing
+// parenthesis or bracket then makes go/printer add a trailing comma.
+//
+// A list is only split if an element starts on a later line than the opening
+// or the previous element, so a call like "f(func() {\n})" is left alone.
+func (f *fumpter) needsTrailingComma(open, close token.Pos, elems []ast.Node) bool {
+	if !close.IsValid() || f.Line(elems[len(elems)-1].End()) != f.Line(close) {
+		return false
+	}
+	prevLine := f.Line(open)
+	for _, elem := range elems {
+		if f.Line(elem.Pos()) > prevLine {
+			return true
+		}
+		prevLine = f.Line(elem.End())
+	}
+	return false
+}
+
+// addTrailingCommaFields is like needsTrailingComma for a list of type
+// parameters, adding the newline if needed.
+func (f *fumpter) addTrailingCommaFields(list *ast.FieldList) {
+	if len(list.List) == 0 {
+		return
+	}
+	elems := make([]ast.Node, len(list.List))
+	for i, field := range list.List {
+		elems[i] = field
+	}
+	if !f.needsTrailingComma(list.Opening, list.Closing, elems) || !f.enabled("trailing-commas") {
+		return
+	}
+	// go/printer compares the closing line with the line where the last
+	// field ends, which is the position of the closing bracket itself if
+	// there is nothing in between. Since the bracket is always followed
+	// by a type or parameters on the same line, point to the next byte.
+	if elems[len(elems)-1].End() == list.Closing {
+		list.Closing++
+	}
+	f.addNewline(list.Closing)
+}
+
+func (f *fumpter) applyPost(c *astutil.Cursor) {
+	switch node := c.Node().(type) {
+	case *ast.CallExpr:
+		if f.CollapseShortCalls && f.enabled("collapse-calls") {
+			f.collapseShortCall(node)
+		}
+		if f.TrailingCommas && len(node.Args) > 0 {
+			elems := make([]ast.Node, len(node.Args))
+			for i, arg := range node.Args {
+				elems[i] = arg
+			}
+			if f.needsTrailingComma(node.Lparen, node.Rparen, elems) && f.enabled("trailing-commas") {
+				f.addNewline(node.Rparen)
+			}
+		}
+
+	case *ast.FuncType:
+		if node.TypeParams != nil && f.CollapseShortTypeParams && f.enabled("collapse-typeparams") {
+			f.collapseShortTypeParams(node.TypeParams)
+		}
+		if node.TypeParams != nil && f.TrailingCommas {
+			f.addTrailingCommaFields(node.TypeParams)
+		}
+
+	case *ast.TypeSpec:
+		if node.TypeParams != nil && f.CollapseShortTypeParams && f.enabled("collapse-typeparams") {
+			f.collapseShortTypeParams(node.TypeParams)
+		}
+		if node.TypeParams != nil && f.TrailingCommas {
+			f.addTrailingCommaFields(node.TypeParams)
+		}
+
+	case *ast.IfStmt:
+		// An empty else block does nothing, so remove it. An "else if"
+		// isn't a block, and comments might explain an empty block.
+		block, ok := node.Else.(*ast.BlockStmt)
+		if !ok || len(block.List) > 0 || len(f.commentsBetween(node.Body.Rbrace, block.Rbrace)) > 0 ||
+			!f.enabled("empty-else") {
+			break
+		}
+		f.record(block.Lbrace)
+		// The else block's lines must go too, or go/printer would
+		// think that there's an empty line after the if statement.
+		f.removeLines(f.Line(node.Body.Rbrace), f.Line(block.Rbrace))
+		node.Else = nil
+
+	// Adding newlines to composite literals happens as a "post" step, so
+	// that we can take into account whether "pre" steps added any newlines
+	// that would affect us here.
+	case *ast.CompositeLit:
+		if len(node.Elts) == 0 {
+			// doesn't have elements
+			break
+		}
+		if !f.enabled("composite-newlines") {
+			break
+		}
+		openLine := f.Line(node.Lbrace)
+		closeLine := f.Line(node.Rbrace)
+		if openLine == closeLine {
+			// all in a single line
+			break
+		}
+
+		newlineAroundElems := false
+		newlineBetweenElems := false
+		lastLine := openLine
+		for i, elem := range node.Elts {
+			if elPos := f.Line(elem.Pos()); elPos > lastLine {
+				if i == 0 {
+					newlineAroundElems = true
+
+					// rm leading lines if they exist,
+					// including around any comments
+					prev := node.Lbrace
+					for _, group := range f.commentsBetween(node.Lbrace, elem.Pos()) {
+						f.removeLinesBetween(prev, group.Pos())
+						prev = group.End()
+					}
+					f.removeLinesBetween(prev, elem.Pos())
+				} else {
+					newlineBetweenElems = true
+				}
+			}
+			lastLine = f.Line(elem.End())
+		}
 		if closeLine > lastLine {
 			newlineAroundElems = true
 		}
@@ -618,8 +1931,8 @@ func (f *fumpter) applyPost(c *astutil.Cursor) {
 			}
 			elem2 := node.Elts[i2]
 			// TODO: do we care about &{}?
-			_, ok1 := elem1.(*ast.CompositeLit)
-			_, ok2 := elem2.(*ast.CompositeLit)
+			_, ok1 := elemValue(elem1).(*ast.CompositeLit)
+			_, ok2 := elemValue(elem2).(*ast.CompositeLit)
 			if !ok1 && !ok2 {
 				continue
 			}
@@ -630,10 +1943,55 @@ func (f *fumpter) applyPost(c *astutil.Cursor) {
 	}
 }
 
+// collapseShortCall joins a call with its arguments on separate lines onto a
+// single line, such as turning "f(\n\targ1,\n\targ2,\n)" into "f(arg1, arg2)".
+// Like with composite literals, this happens as a "post" step, as the arguments
+// might have been modified.
+func (f *fumpter) collapseShortCall(call *ast.CallExpr) {
+	if len(call.Args) == 0 {
+		return
+	}
+	openLine := f.Line(call.Lparen)
+	closeLine := f.Line(call.Rparen)
+	if openLine == closeLine {
+		// nothing to do
+		return
+	}
+	if f.Line(call.Pos()) != openLine {
+		// the func is multi-line
+		return
+	}
+	// Both printed lengths include the indentation, so subtract it for
+	// all but one of them.
+	length := f.printLength(call.Fun) + len("()")
+	lastLine := openLine
+	for _, arg := range call.Args {
+		if f.Line(arg.Pos()) != f.Line(arg.End()) {
+			// the argument is multi-line, such as an expanded
+			// composite literal
+			return
+		}
+		if f.Line(arg.Pos()) == lastLine {
+			// not one argument per line
+			return
+		}
+		lastLine = f.Line(arg.End())
+		length += f.printLength(arg) - f.blockLevel*8
+	}
+	if len(f.commentsBetween(call.Lparen, call.Rparen)) > 0 {
+		// don't move comments
+		return
+	}
+	length += len(", ") * (len(call.Args) - 1)
+	if length > shortLineLimit {
+		// too long to collapse
+		return
+	}
+	f.removeLines(openLine, closeLine)
+}
+
 func (f *fumpter) splitLongLine(c *astutil.Cursor) {
-	if os.Getenv("GOFUMPT_SPLIT_LONG_LINES") != "on" {
-		// By default, this feature is turned off.
-		// Turn it on by setting GOFUMPT_SPLIT_LONG_LINES=on.
+	if !f.SplitLongLines || !f.enabled("long-lines") {
 		return
 	}
 	node := c.Node()
@@ -650,6 +2008,10 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 		return
 	}
 
+	if inst, ok := node.(*ast.IndexListExpr); ok && f.splitInstantiation(inst, start) {
+		return
+	}
+
 	// Only split at the start of the current node if it's part of a list.
 	if _, ok := c.Parent().(*ast.BinaryExpr); ok {
 		// Chains of binary expressions are considered lists, too.
@@ -659,6 +2021,10 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 		return
 	}
 
+	if f.noSplit[c.Parent()] {
+		return
+	}
+
 	// Like in printLength, add an approximation of the indentation level.
 	// Since any existing tabs were already counted as one column, multiply
 	// the level by 7.
@@ -713,6 +2079,39 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 	}
 }
 
+// splitInstantiation splits a long generic instantiation so that each of its
+// type arguments is on a separate line, like the elements of a composite
+// literal. It reports whether the instantiation was split.
+func (f *fumpter) splitInstantiation(inst *ast.IndexListExpr, start token.Position) bool {
+	// Splitting before a single type argument would look out of place, so
+	// we either split all of them or none.
+	f.markNoSplit(inst)
+
+	lineEnd := f.Position(f.lineEnd(start.Line))
+	if lineEnd.Column+f.blockLevel*7 <= longLineLimit {
+		return false
+	}
+	length := f.Position(inst.Rbrack).Column - f.Position(inst.Lbrack).Column
+	if length < int(f.minSplitFactor*longLineLimit) {
+		// too short to be worth splitting
+		return false
+	}
+	for _, index := range inst.Indices {
+		f.addNewline(index.Pos())
+	}
+	f.addNewline(inst.Rbrack)
+	return true
+}
+
+// elemValue returns the value of a composite literal element, which is the
+// element itself unless it's a key-value pair, such as in a map.
+func elemValue(elem ast.Expr) ast.Expr {
+	if kv, ok := elem.(*ast.KeyValueExpr); ok {
+		return kv.Value
+	}
+	return elem
+}
+
 func isComposite(node ast.Node) *ast.CompositeLit {
 	switch node := node.(type) {
 	case *ast.CompositeLit:
@@ -724,8 +2123,180 @@ func isComposite(node ast.Node) *ast.CompositeLit {
 	}
 }
 
+// flattenElse returns list with the else blocks of its if statements moved
+// after them, if the if block always ends in a terminating statement. If list
+// is a func body, sign is the func's signature.
+//
+// An else block is left alone if it's an "else if", if there are comments
+// next to its braces which could end up in the wrong place, or if it
+// declares names which appear elsewhere in list or in sign, as the
+// declarations could then clash or shadow other names. Labels are left alone
+// too, as a goto could then jump over the moved declarations.
+func (f *fumpter) flattenElse(list []ast.Stmt, sign *ast.FuncType) []ast.Stmt {
+	// The moved statements are looked at too, as they may hold more if
+	// statements to flatten.
+	for i := 0; i < len(list); i++ {
+		ifs, ok := list[i].(*ast.IfStmt)
+		if !ok || ifs.Init != nil || len(ifs.Body.List) == 0 ||
+			!isTerminating(ifs.Body.List[len(ifs.Body.List)-1]) {
+			continue
+		}
+		block, ok := ifs.Else.(*ast.BlockStmt)
+		if !ok || len(block.List) == 0 {
+			continue
+		}
+		// Comments on their own lines before the first statement can
+		// stay, but not the ones on the "} else {" line.
+		first, last := block.List[0], block.List[len(block.List)-1]
+		if comments := f.commentsBetween(ifs.Body.Rbrace, first.Pos()); len(comments) > 0 &&
+			f.Line(comments[0].Pos()) == f.Line(block.Lbrace) {
+			continue
+		}
+		if len(f.commentsBetween(last.End(), block.Rbrace)) > 0 {
+			continue
+		}
+		if names := declaredNames(block.List); len(names) > 0 {
+			if sign != nil && mentionsAny(sign, names, nil) {
+				continue
+			}
+			clash := false
+			for _, other := range list {
+				if _, ok := other.(*ast.LabeledStmt); ok || mentionsAny(other, names, block) {
+					clash = true
+					break
+				}
+			}
+			if clash {
+				continue
+			}
+		}
+		f.record(block.Lbrace)
+		// The closing brace's line must go too, or go/printer would
+		// think that there's an empty line after the moved statements.
+		f.removeLines(f.Line(last.End()), f.Line(block.Rbrace))
+		ifs.Else = nil
+		flat := append(append([]ast.Stmt(nil), list[:i+1]...), block.List...)
+		list = append(flat, list[i+1:]...)
+	}
+	return list
+}
+
+// isTerminating reports whether stmt always leaves the current block, as a
+// return, a break, a continue, a goto, or a call to the predeclared panic.
+func isTerminating(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return stmt.Tok != token.FALLTHROUGH
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		id, ok := call.Fun.(*ast.Ident)
+		return ok && id.Name == "panic" && id.Obj == nil
+	}
+	return false
+}
+
+// declaredNames returns the names declared by the statements in list, not
+// counting the ones in nested blocks. Labels are included, as they can't be
+// moved either.
+func declaredNames(list []ast.Stmt) map[string]bool {
+	names := make(map[string]bool)
+	for _, stmt := range list {
+		switch stmt := stmt.(type) {
+		case *ast.AssignStmt:
+			if stmt.Tok != token.DEFINE {
+				break
+			}
+			for _, expr := range stmt.Lhs {
+				if id, ok := expr.(*ast.Ident); ok && id.Name != "_" {
+					names[id.Name] = true
+				}
+			}
+		case *ast.DeclStmt:
+			for _, spec := range stmt.Decl.(*ast.GenDecl).Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						if id.Name != "_" {
+							names[id.Name] = true
+						}
+					}
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = true
+				}
+			}
+		case *ast.LabeledStmt:
+			names[stmt.Label.Name] = true
+		}
+	}
+	return names
+}
+
+// mentionsAny reports whether any identifier in node, outside of skip, has
+// one of the given names.
+func mentionsAny(node ast.Node, names map[string]bool, skip ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		if found || (skip != nil && node == skip) {
+			return false
+		}
+		if id, ok := node.(*ast.Ident); ok && names[id.Name] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// removeEmptyStmts returns list without its empty statements, such as stray
+// semicolons. The ones which are labeled aren't part of the list, so they are
+// kept. The open and close positions are those of the braces or colon around
+// the list; close may be invalid.
+func (f *fumpter) removeEmptyStmts(list []ast.Stmt, open, close token.Pos) []ast.Stmt {
+	var kept []ast.Stmt
+	for i, stmt := range list {
+		if _, ok := stmt.(*ast.EmptyStmt); !ok {
+			kept = append(kept, stmt)
+			continue
+		}
+		f.record(stmt.Pos())
+
+		// If the semicolon was on a line of its own, remove the line
+		// too, unless it has any comments.
+		prevEnd, nextPos := open, close
+		if i > 0 {
+			prevEnd = list[i-1].End()
+		}
+		if i+1 < len(list) {
+			nextPos = list[i+1].Pos()
+		}
+		line := f.Line(stmt.Pos())
+		if f.Line(prevEnd) == line || (nextPos.IsValid() && f.Line(nextPos) == line) {
+			continue
+		}
+		if comments := f.commentsBetween(prevEnd, stmt.Pos()); len(comments) > 0 &&
+			f.Line(comments[len(comments)-1].End()) == line {
+			continue
+		}
+		if f.inlineComment(stmt.End()) != nil {
+			continue
+		}
+		f.removeLines(line-1, line)
+	}
+	return kept
+}
+
 func (f *fumpter) stmts(list []ast.Stmt) {
 	for i, stmt := range list {
+		if i > 0 && f.Line(list[i-1].End()) == f.Line(stmt.Pos()) && f.enabled("stmt-semicolons") {
+			// Statements separated by semicolons on a single line,
+			// which go/printer keeps as-is in one-line func bodies.
+			f.addNewline(stmt.Pos())
+		}
 		ifs, ok := stmt.(*ast.IfStmt)
 		if !ok || i < 1 {
 			continue // not an if following another statement
@@ -743,10 +2314,74 @@ func (f *fumpter) stmts(list []ast.Stmt) {
 			!identEqual(be.Y, "nil") {
 			continue // not "err != nil"
 		}
+		if !f.enabled("err-check-spacing") {
+			continue
+		}
 		f.removeLinesBetween(as.End(), ifs.Pos())
 	}
 }
 
+// attachDocComments removes the empty line between a top-level declaration and
+// the comment directly above it, which was likely meant to be its doc comment.
+// Comments separated by more than one empty line may be standalone, so they
+// are left alone, as are comments which look like directives.
+func (f *fumpter) attachDocComments(file *ast.File) {
+	lastEnd := file.Name.End()
+	for _, decl := range file.Decls {
+		var doc *ast.CommentGroup
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			doc = decl.Doc
+		case *ast.FuncDecl:
+			doc = decl.Doc
+		}
+		prevEnd := lastEnd
+		lastEnd = decl.End()
+		comments := f.commentsBetween(prevEnd, decl.Pos())
+		if doc != nil || len(comments) == 0 {
+			continue
+		}
+		group := comments[len(comments)-1]
+		if f.Line(group.Pos()) == f.Line(prevEnd) {
+			continue // an inline comment after the previous line
+		}
+		if f.Line(decl.Pos())-f.Line(group.End()) != 2 {
+			continue // not separated by exactly one empty line
+		}
+		body := strings.TrimPrefix(group.List[0].Text, "//")
+		if body != group.List[0].Text && rxCommentDirective.MatchString(body) {
+			continue
+		}
+		f.removeLinesBetween(group.End(), decl.Pos())
+	}
+}
+
+// isInitFunc reports whether decl is a package initialization func.
+func isInitFunc(decl ast.Decl) bool {
+	fd, ok := decl.(*ast.FuncDecl)
+	return ok && fd.Recv == nil && fd.Name.Name == "init"
+}
+
+// declaresAny reports whether a file might declare an identifier named "any",
+// shadowing the predeclared one. Without type information, we rely on the
+// objects resolved by the parser, and assume that dot imports may declare it.
+// Declarations in other files of the same package aren't seen.
+func declaresAny(file *ast.File) bool {
+	for _, imp := range file.Imports {
+		if imp.Name != nil && imp.Name.Name == "." {
+			return true
+		}
+	}
+	found := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Ident); ok && id.Name == "any" && id.Obj != nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
 func identEqual(expr ast.Expr, name string) bool {
 	id, ok := expr.(*ast.Ident)
 	return ok && id.Name == name
@@ -765,10 +2400,99 @@ func isCgoImport(decl *ast.GenDecl) bool {
 	return spec.Path.Value == `"C"`
 }
 
+// mergeImportDecls merges consecutive import declarations into the first one,
+// if any of them uses parentheses. Joining lone imports without parentheses is
+// left to the rule which joins other lone declarations. Cgo imports are never
+// merged, as they may be preceded by a preamble. The doc comment of a lone
+// import becomes the doc comment of its spec, but parenthesized declarations
+// with a doc comment are not merged, as the comment describes the whole block.
+func (f *fumpter) mergeImportDecls(file *ast.File) {
+	newDecls := make([]ast.Decl, 0, len(file.Decls))
+	for i := 0; i < len(file.Decls); {
+		decl := file.Decls[i]
+		newDecls = append(newDecls, decl)
+		i++
+		start, ok := decl.(*ast.GenDecl)
+		if !ok || start.Tok != token.IMPORT || isCgoImport(start) {
+			continue
+		}
+		var run []*ast.GenDecl
+		for ; i < len(file.Decls); i++ {
+			cont, ok := file.Decls[i].(*ast.GenDecl)
+			if !ok || cont.Tok != token.IMPORT || isCgoImport(cont) ||
+				(cont.Doc != nil && cont.Lparen.IsValid()) {
+				break
+			}
+			run = append(run, cont)
+		}
+		parens := start.Lparen.IsValid()
+		for _, cont := range run {
+			parens = parens || cont.Lparen.IsValid()
+		}
+		if !parens {
+			for _, cont := range run {
+				newDecls = append(newDecls, cont)
+			}
+			continue
+		}
+		if !start.Lparen.IsValid() {
+			// Right after the "import" keyword; ast.SortImports
+			// ignores declarations without parentheses.
+			start.Lparen = start.TokPos + token.Pos(len("import"))
+		}
+		for _, cont := range run {
+			f.record(cont.Pos())
+			if cont.Doc != nil {
+				cont.Specs[0].(*ast.ImportSpec).Doc = cont.Doc
+				cont.Doc = nil
+			}
+			start.Specs = append(start.Specs, cont.Specs...)
+			if c := f.inlineComment(cont.End()); c != nil {
+				// don't move an inline comment outside
+				start.Rparen = c.End()
+			} else {
+				start.Rparen = cont.End()
+			}
+		}
+	}
+	file.Decls = newDecls
+}
+
+// parenthesizeImports adds parentheses to all import declarations without
+// them, other than cgo imports. go/printer puts the specs of a parenthesized
+// declaration on separate lines.
+func (f *fumpter) parenthesizeImports(file *ast.File) {
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT || decl.Lparen.IsValid() || isCgoImport(decl) {
+			continue
+		}
+		f.record(decl.Pos())
+		decl.Lparen = decl.TokPos + token.Pos(len("import"))
+		decl.Rparen = decl.End()
+		if c := f.inlineComment(decl.End()); c != nil {
+			// don't move an inline comment outside
+			decl.Rparen = c.End()
+		}
+	}
+}
+
 // joinStdImports ensures that all standard library imports are together and at
-// the top of the imports list.
+// the top of the imports list. If LocalPrefixes is set, it also ensures that
+// all local imports are together and at the bottom.
 func (f *fumpter) joinStdImports(d *ast.GenDecl) {
-	var std, other []ast.Spec
+	// Local imports after the last non-local import are already in place.
+	lastNonLocal := -1
+	if f.groupLocalImports() {
+		for i, spec := range d.Specs {
+			path, _ := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+			if !f.isLocalImport(path) {
+				lastNonLocal = i
+			}
+		}
+	}
+
+	var std, other, local, movedLocal []ast.Spec
 	firstGroup := true
 	lastEnd := d.Pos()
 	needsSort := false
@@ -785,6 +2509,34 @@ func (f *fumpter) joinStdImports(d *ast.GenDecl) {
 		}
 
 		path, _ := strconv.Unquote(spec.Path.Value)
+		if f.groupLocalImports() && f.isLocalImport(path) {
+			switch {
+			case i > lastNonLocal:
+				local = append(local, spec)
+			// Like with std imports below, don't move imports with
+			// comments, as the comments would stay behind.
+			case spec.Doc != nil || spec.Comment != nil:
+				other = append(other, spec)
+			default:
+				// Move the import to the bottom, resetting its
+				// position to the closing parenthesis, and
+				// remove the line it leaves behind.
+				// groupLocalImports checked that the rule is
+				// enabled, but other rules may have run since.
+				f.setRule("local-imports")
+				f.record(spec.Pos())
+				line := f.Line(spec.Pos())
+				f.removeLines(line-1, line)
+				setImportPos(spec, d.Rparen)
+				movedLocal = append(movedLocal, spec)
+				needsSort = true
+			}
+			continue
+		}
+		if !f.enabled("std-imports") {
+			other = append(other, spec)
+			continue
+		}
 		switch {
 		// Imports with a period are definitely third party.
 		case strings.Contains(path, "."):
@@ -795,42 +2547,182 @@ func (f *fumpter) joinStdImports(d *ast.GenDecl) {
 			strings.HasPrefix(path, "example/") ||
 			strings.HasPrefix(path, "internal/"):
 			fallthrough
-		// To be conservative, if an import has a name or an inline
-		// comment, and isn't part of the top group, treat it as non-std.
-		case !firstGroup && (spec.Name != nil || spec.Comment != nil):
+		// To be conservative, if an import has a name or a comment,
+		// and isn't part of the top group, treat it as non-std.
+		case !firstGroup && (spec.Name != nil || spec.Comment != nil || spec.Doc != nil):
 			other = append(other, spec)
 			continue
 		}
 
 		// If we're moving this std import further up, reset its
 		// position, to avoid breaking comments.
-		if !firstGroup || len(other) > 0 {
-			setPos(reflect.ValueOf(spec), d.Pos())
+		if !firstGroup || len(other) > 0 || len(local) > 0 || len(movedLocal) > 0 {
+			f.record(spec.Pos())
+			setImportPos(spec, d.Pos())
 			needsSort = true
 		}
 		std = append(std, spec)
 	}
+	local = append(local, movedLocal...)
+	nonStd := append(other, local...)
+
 	// Ensure there is an empty line between std imports and other imports.
-	if len(std) > 0 && len(other) > 0 && f.Line(std[len(std)-1].End())+1 >= f.Line(other[0].Pos()) {
+	// There are only std imports if the rule is enabled.
+	f.setRule("std-imports")
+	// The empty line goes before the doc comment of the first non-std
+	// import, if any, so that the comment stays with its import.
+	if len(std) > 0 && len(nonStd) > 0 && f.Line(std[len(std)-1].End())+1 >= f.Line(f.importStart(nonStd[0])) {
 		// We add two newlines, as that's necessary in some edge cases.
 		// For example, if the std and non-std imports were together and
 		// without indentation, adding one newline isn't enough. Two
 		// empty lines will be printed as one by go/printer, anyway.
-		f.addNewline(other[0].Pos() - 1)
-		f.addNewline(other[0].Pos())
+		start := f.importStart(nonStd[0])
+		f.addNewline(start - 1)
+		f.addNewline(start)
+	}
+	// Likewise, between other imports and local imports.
+	if len(other) > 0 && len(local) > 0 && f.groupLocalImports() {
+		last := other[len(other)-1].(*ast.ImportSpec)
+		if start := f.importStart(local[0]); f.Line(last.End())+1 >= f.Line(start) {
+			// Moved local imports are at the closing parenthesis,
+			// right after the newline at the end of the last import.
+			// To give that newline its own line, make the last import
+			// end at its last character.
+			if last.End() == start-1 {
+				last.EndPos = last.End() - 1
+			}
+			f.addNewline(start - 1)
+			f.addNewline(start)
+		}
 	}
 	// Finally, join the imports, keeping std at the top.
-	d.Specs = append(std, other...)
+	d.Specs = append(std, nonStd...)
 
 	// If we moved any std imports to the first group, we need to sort them
 	// again.
-	if needsSort {
+	if f.PreserveNonStdImportOrder {
+		// Only sort the std imports, via a file holding just them.
+		// Its closing parenthesis goes right after them, as
+		// ast.SortImports removes empty lines before it.
+		stdDecl := &ast.GenDecl{Tok: token.IMPORT, Lparen: d.Lparen, Specs: std}
+		for _, spec := range std {
+			if spec.End() > stdDecl.Rparen {
+				stdDecl.Rparen = spec.End()
+			}
+		}
+		ast.SortImports(f.fset, &ast.File{Decls: []ast.Decl{stdDecl}})
+		d.Specs = append(stdDecl.Specs, nonStd...)
+	} else if needsSort {
 		ast.SortImports(f.fset, f.astFile)
 	}
 }
 
+// importStart returns the position where an import spec starts, including its
+// doc comment. Since ast.SortImports may move specs away from their doc
+// comments, a doc comment is only used if it's right above the spec.
+func (f *fumpter) importStart(spec ast.Spec) token.Pos {
+	if doc := spec.(*ast.ImportSpec).Doc; doc != nil && f.Line(doc.End())+1 == f.Line(spec.Pos()) {
+		return doc.Pos()
+	}
+	return spec.Pos()
+}
+
+// groupLocalImports reports whether local imports should be grouped at the
+// bottom of import blocks, as set via LocalPrefixes.
+func (f *fumpter) groupLocalImports() bool {
+	return len(f.LocalPrefixes) > 0 && f.enabled("local-imports")
+}
+
+// isLocalImport reports whether an import path matches one of LocalPrefixes.
+func (f *fumpter) isLocalImport(path string) bool {
+	for _, prefix := range f.LocalPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeImportComment makes the comment of a blank or dot import a line
+// comment after the spec, adding ImportCommentPlaceholder if there is none.
+// Comments spanning multiple lines are left alone.
+func (f *fumpter) normalizeImportComment(decl *ast.GenDecl, spec *ast.ImportSpec) {
+	if spec.Name == nil || (spec.Name.Name != "_" && spec.Name.Name != ".") {
+		return
+	}
+	if spec.End() <= spec.Pos() || (decl.Rparen.IsValid() && spec.End() >= decl.Rparen) {
+		// The spec was moved, such as when merging import declarations,
+		// so we can't tell where its comments should go.
+		return
+	}
+	switch {
+	case !decl.Lparen.IsValid() && decl.Doc != nil:
+		// The doc comment of a lone import belongs to the declaration.
+	case spec.Comment != nil:
+		if len(spec.Comment.List) != 1 {
+			return
+		}
+		comment := spec.Comment.List[0]
+		if text, ok := lineCommentText(comment.Text); ok && text != comment.Text {
+			f.record(comment.Pos())
+			comment.Text = text
+		}
+	case spec.Doc != nil:
+		// Only move a doc comment which is right above the spec, as
+		// ast.SortImports may move specs away from their comments.
+		line := f.Line(spec.Pos())
+		if len(spec.Doc.List) != 1 || f.Line(spec.Doc.Pos())+1 != line {
+			return
+		}
+		comment := spec.Doc.List[0]
+		text, ok := lineCommentText(comment.Text)
+		if !ok || rxCommentDirective.MatchString(strings.TrimPrefix(text, "//")) {
+			return
+		}
+		f.record(comment.Pos())
+		f.removeLines(line-1, line)
+		comment.Text = text
+		comment.Slash = spec.End()
+		spec.Comment, spec.Doc = spec.Doc, nil
+	case f.ImportCommentPlaceholder != "":
+		f.record(spec.End())
+		group := &ast.CommentGroup{List: []*ast.Comment{{
+			Slash: spec.End(),
+			Text:  "// " + f.ImportCommentPlaceholder,
+		}}}
+		comments := f.astFile.Comments
+		i := sort.Search(len(comments), func(i int) bool {
+			return comments[i].Pos() >= spec.End()
+		})
+		comments = append(comments, nil)
+		copy(comments[i+1:], comments[i:])
+		comments[i] = group
+		f.astFile.Comments = comments
+		spec.Comment = group
+	}
+}
+
+// lineCommentText returns the text of a single-line comment as a line comment
+// with a single space after the slashes, like "// text". Line comments which
+// don't start with a space, such as directives, are returned unchanged. It
+// reports false for empty block comments and those spanning multiple lines.
+func lineCommentText(text string) (string, bool) {
+	if body := strings.TrimPrefix(text, "//"); body != text {
+		if body == "" || (body[0] != ' ' && body[0] != '\t') {
+			return text, true
+		}
+		return "// " + strings.TrimSpace(body), true
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/"))
+	if body == "" || strings.Contains(body, "\n") {
+		return "", false
+	}
+	return "// " + body, true
+}
+
 // mergeAdjacentFields returns fields with adjacent fields merged if possible.
-func (f *fumpter) mergeAdjacentFields(fields []*ast.Field) []*ast.Field {
+func (f *fumpter) mergeAdjacentFields(fields []*ast.Field, split bool) []*ast.Field {
 	// If there are less than two fields then there is nothing to merge.
 	if len(fields) < 2 {
 		return fields
@@ -840,14 +2732,37 @@ func (f *fumpter) mergeAdjacentFields(fields []*ast.Field) []*ast.Field {
 	// and mutating fields. Elements of fields may be mutated (if merged with
 	// following fields), discarded (if merged with a preceeding field), or left
 	// unchanged.
+	//
+	// removed is how many columns merging has removed so far from the line
+	// of fields[i]. If a merged field would still end past the long line
+	// limit, we stop merging. If split is true, we also move the rest of
+	// the fields to a new line, which go/printer indents once.
 	i := 0
+	removed := 0
 	for j := 1; j < len(fields); j++ {
-		if f.shouldMergeAdjacentFields(fields[i], fields[j]) {
-			fields[i].Names = append(fields[i].Names, fields[j].Names...)
-		} else {
+		if !f.shouldMergeAdjacentFields(fields[i], fields[j]) {
 			i++
 			fields[i] = fields[j]
+			removed = 0
+			continue
 		}
+		typeLen := f.Offset(fields[i].Type.End()) - f.Offset(fields[i].Type.Pos())
+		// The type and the space before it are removed.
+		if f.tabbedColumn(fields[j].End())-removed-typeLen-1 > longLineLimit {
+			i++
+			fields[i] = fields[j]
+			if !split {
+				continue
+			}
+			f.addNewline(fields[j].Pos())
+			// The new line will be indented with one more tab,
+			// and none of its tabs are in the source yet.
+			removed = -8 - f.blockLevel
+			continue
+		}
+		f.record(fields[j].Pos())
+		fields[i].Names = append(fields[i].Names, fields[j].Names...)
+		removed += typeLen + 1
 	}
 	return fields[:i+1]
 }
@@ -867,22 +2782,13 @@ func (f *fumpter) shouldMergeAdjacentFields(f1, f2 *ast.Field) bool {
 	return cmp.Equal(f1.Type, f2.Type, opt)
 }
 
-var posType = reflect.TypeOf(token.NoPos)
-
-// setPos recursively sets all position fields in the node v to pos.
-func setPos(v reflect.Value, pos token.Pos) {
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	if !v.IsValid() {
-		return
-	}
-	if v.Type() == posType {
-		v.Set(reflect.ValueOf(pos))
-	}
-	if v.Kind() == reflect.Struct {
-		for i := 0; i < v.NumField(); i++ {
-			setPos(v.Field(i), pos)
-		}
+// setImportPos sets all positions in an import spec to pos.
+func setImportPos(spec *ast.ImportSpec, pos token.Pos) {
+	if spec.Name != nil {
+		spec.Name.NamePos = pos
 	}
+	// Reset the whole literal, as newer Go versions also record where it
+	// ends.
+	*spec.Path = ast.BasicLit{ValuePos: pos, Kind: spec.Path.Kind, Value: spec.Path.Value}
+	spec.EndPos = pos
 }
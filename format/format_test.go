@@ -0,0 +1,2702 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format_test
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"golang.org/x/tools/go/packages"
+
+	"mvdan.cc/gofumpt/format"
+)
+
+func TestSourceOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts format.Options
+		in   string
+		want string
+	}{
+		{
+			name: "BlankAfterMultilineCondition",
+			in: `package p
+
+func f() {
+	if true &&
+		true {
+
+		println()
+	}
+	for true &&
+		true {
+
+		println()
+	}
+}
+`,
+			want: `package p
+
+func f() {
+	if true &&
+		true {
+
+		println()
+	}
+	for true &&
+		true {
+
+		println()
+	}
+}
+`,
+		},
+		{
+			name: "NoBlankAfterMultilineCondition",
+			opts: format.Options{NoBlankAfterMultilineCondition: true},
+			in: `package p
+
+func f() {
+	if true &&
+		true {
+
+		println()
+	}
+	for true &&
+		true {
+
+		println()
+	}
+}
+`,
+			want: `package p
+
+func f() {
+	if true &&
+		true {
+		println()
+	}
+	for true &&
+		true {
+		println()
+	}
+}
+`,
+		},
+		{
+			name: "KeepInterfaces",
+			in: `package p
+
+type Reader interface {
+	Read(p []byte) (n int, err error)
+}
+`,
+			want: `package p
+
+type Reader interface {
+	Read(p []byte) (n int, err error)
+}
+`,
+		},
+		{
+			name: "CollapseShortInterfaces",
+			opts: format.Options{CollapseShortInterfaces: true},
+			in: `package p
+
+type Reader interface {
+	Read(p []byte) (int, error)
+}
+
+type ReadCloser interface {
+	Read(p []byte) (n int, err error)
+	Close() error
+}
+
+type Documented interface {
+	// Close closes.
+	Close() error
+}
+
+type Long interface {
+	Read(p []byte) (n int, err error)
+}
+
+type Embedded interface {
+	io.Reader
+}
+
+type Number interface {
+	~int | ~float64
+}
+
+func f(v interface {
+	String() string
+}) {
+}
+`,
+			want: `package p
+
+type Reader interface{ Read(p []byte) (int, error) }
+
+type ReadCloser interface {
+	Read(p []byte) (n int, err error)
+	Close() error
+}
+
+type Documented interface {
+	// Close closes.
+	Close() error
+}
+
+type Long interface {
+	Read(p []byte) (n int, err error)
+}
+
+type Embedded interface {
+	io.Reader
+}
+
+type Number interface {
+	~int | ~float64
+}
+
+func f(v interface{ String() string }) {
+}
+`,
+		},
+		{
+			name: "KeepCalls",
+			in: `package p
+
+func f() {
+	println(
+		"short",
+	)
+	fmt.Println(
+		"a much longer argument which doesn't fit in a short line",
+	)
+	println(
+		// comment
+		"short",
+	)
+	println(
+		"short", // comment
+	)
+	println(
+		args...,
+	)
+	println(
+		"two",
+		"args",
+	)
+	println([]string{
+		"composite",
+	})
+	println(
+		[]string{
+			"composite",
+		},
+	)
+}
+`,
+			want: `package p
+
+func f() {
+	println(
+		"short",
+	)
+	fmt.Println(
+		"a much longer argument which doesn't fit in a short line",
+	)
+	println(
+		// comment
+		"short",
+	)
+	println(
+		"short", // comment
+	)
+	println(
+		args...,
+	)
+	println(
+		"two",
+		"args",
+	)
+	println([]string{
+		"composite",
+	})
+	println(
+		[]string{
+			"composite",
+		},
+	)
+}
+`,
+		},
+		{
+			name: "CollapseShortCalls",
+			opts: format.Options{CollapseShortCalls: true},
+			in: `package p
+
+func f() {
+	println(
+		"short",
+	)
+	fmt.Println(
+		"a much longer argument which doesn't fit in a short line",
+	)
+	println(
+		// comment
+		"short",
+	)
+	println(
+		"short", // comment
+	)
+	println(
+		args...,
+	)
+	println(
+		"two",
+		"args",
+	)
+	println(
+		"aaaaaaaaaaaaaaaaaa",
+		"bbbbbbbbbbbbbbbbbbb",
+	)
+	println(
+		"aaaaaaaaaaaaaaaaaa",
+		"bbbbbbbbbbbbbbbbbbbb",
+	)
+	println(
+		"two", "per",
+		"line",
+	)
+	println("first",
+		"second")
+	println(
+		"two",
+		"args", // comment
+	)
+	println([]string{
+		"composite",
+	})
+	println(
+		[]string{
+			"composite",
+		},
+	)
+}
+`,
+			want: `package p
+
+func f() {
+	println("short")
+	fmt.Println(
+		"a much longer argument which doesn't fit in a short line",
+	)
+	println(
+		// comment
+		"short",
+	)
+	println(
+		"short", // comment
+	)
+	println(args...)
+	println("two", "args")
+	println("aaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbb")
+	println(
+		"aaaaaaaaaaaaaaaaaa",
+		"bbbbbbbbbbbbbbbbbbbb",
+	)
+	println(
+		"two", "per",
+		"line",
+	)
+	println("first",
+		"second")
+	println(
+		"two",
+		"args", // comment
+	)
+	println([]string{
+		"composite",
+	})
+	println(
+		[]string{
+			"composite",
+		},
+	)
+}
+`,
+		},
+		{
+			name: "CollapseShortTypeParams",
+			opts: format.Options{CollapseShortTypeParams: true},
+			in: `package p
+
+func Map[
+	K comparable,
+	V any,
+](m map[K]V) {
+}
+
+type Pair[
+	A any,
+	B any,
+] struct{}
+
+type Long[
+	FirstElement interface{ ~int | ~int64 },
+	SecondElement interface{ ~string },
+] struct{}
+
+type Commented[
+	A any, // comment
+	B any,
+] struct{}
+
+type Grouped[
+	A, B any,
+	C any,
+] struct{}
+`,
+			want: `package p
+
+func Map[K comparable, V any](m map[K]V) {
+}
+
+type Pair[A any, B any] struct{}
+
+type Long[
+	FirstElement interface{ ~int | ~int64 },
+	SecondElement interface{ ~string },
+] struct{}
+
+type Commented[
+	A any, // comment
+	B any,
+] struct{}
+
+type Grouped[A, B any, C any] struct{}
+`,
+		},
+		{
+			name: "RawStringFormatters",
+			opts: format.Options{RawStringFormatters: map[string]func(string) string{
+				"sql": func(s string) string {
+					fields := strings.Fields(s)
+					for i, field := range fields {
+						if field == "select" || field == "from" {
+							fields[i] = "\n" + strings.ToUpper(field)
+						}
+					}
+					return strings.Join(fields, " ") + "\n"
+				},
+				"bad":     func(s string) string { return s + "`" },
+				"oneline": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+			}},
+			in: `package p
+
+const q1 = //sql:
+` + "`select a from t`" + `
+
+const q2 = ` + "`select a from t`" + `
+
+func f() {
+	query( //sql:
+		` + "`select b from u`" + `)
+
+	//bad:
+	_ = ` + "`raw`" + `
+
+	//other:
+	_ = ` + "`select a from t`" + `
+
+	//sql:
+	_ = "select a from t"
+
+	//oneline:
+	_ = ` + "`select a\n\tfrom t\n`" + `
+
+	println()
+}
+`,
+			want: `package p
+
+const q1 = //sql:
+` + "`\nSELECT a \nFROM t\n`" + `
+
+const q2 = ` + "`select a from t`" + `
+
+func f() {
+	query( //sql:
+		` + "`\nSELECT b \nFROM u\n`" + `)
+
+	//bad:
+	_ = ` + "`raw`" + `
+
+	// other:
+	_ = ` + "`select a from t`" + `
+
+	//sql:
+	_ = "select a from t"
+
+	//oneline:
+	_ = ` + "`select a from t`" + `
+
+	println()
+}
+`,
+		},
+		{
+			name: "SeparateInitFuncs",
+			opts: format.Options{SeparateInitFuncs: true},
+			in: `package p
+
+var x int
+func init() { x = 1 }
+func init() { x++ }
+var y int
+// z is documented.
+var z int
+
+func init() {
+	y = 2
+}
+var w int
+`,
+			want: `package p
+
+var x int
+
+func init() { x = 1 }
+
+func init() { x++ }
+
+var y int
+
+// z is documented.
+var z int
+
+func init() {
+	y = 2
+}
+
+var w int
+`,
+		},
+		{
+			name: "SplitLongLines",
+			opts: format.Options{SplitLongLines: true},
+			in: `package p
+
+func _() {
+	if err := f(argument1, argument2, argument3, argument4, argument5, argument6, argument7, argument8, argument9, argument10); err != nil {
+		panic(err)
+	}
+}
+`,
+			want: `package p
+
+func _() {
+	if err := f(argument1, argument2, argument3, argument4, argument5, argument6, argument7,
+		argument8, argument9, argument10); err != nil {
+		panic(err)
+	}
+}
+`,
+		},
+		{
+			name: "SplitLongLinesDisabled",
+			in: `package p
+
+func _() {
+	if err := f(argument1, argument2, argument3, argument4, argument5, argument6, argument7, argument8, argument9, argument10); err != nil {
+		panic(err)
+	}
+}
+`,
+			want: `package p
+
+func _() {
+	if err := f(argument1, argument2, argument3, argument4, argument5, argument6, argument7, argument8, argument9, argument10); err != nil {
+		panic(err)
+	}
+}
+`,
+		},
+		{
+			name: "UnifyReceiverNames",
+			opts: format.Options{UnifyReceiverNames: true},
+			in: `package p
+
+type T struct{ n int }
+
+func (t *T) A() int { return t.n }
+
+func (t *T) B() int { return t.n * 2 }
+
+func (t T) C() int { return t.n * 3 }
+
+func (self *T) D() int {
+	f := func() int { return self.n }
+	g := func(self *T) int { return self.n }
+	return f() + g(nil)
+}
+
+func (self T) E() {
+	func() {
+		self := 3
+		println(self)
+	}()
+	println(self.n)
+}
+
+// The new name is already used, so this method is left alone.
+func (self *T) F() int {
+	t := self.n
+	return t
+}
+
+type U struct{}
+
+func (u U) A() {}
+
+func (x *U) B() {}
+
+func (_ *U) C() {}
+
+func (*U) D() {}
+
+type G[E any] struct{ e E }
+
+func (g G[E]) A() E { return g.e }
+
+func (gg G[E]) B() E { return gg.e }
+
+func (g *G[E]) C() E { return g.e }
+`,
+			want: `package p
+
+type T struct{ n int }
+
+func (t *T) A() int { return t.n }
+
+func (t *T) B() int { return t.n * 2 }
+
+func (t T) C() int { return t.n * 3 }
+
+func (t *T) D() int {
+	f := func() int { return t.n }
+	g := func(self *T) int { return self.n }
+	return f() + g(nil)
+}
+
+func (t T) E() {
+	func() {
+		self := 3
+		println(self)
+	}()
+	println(t.n)
+}
+
+// The new name is already used, so this method is left alone.
+func (self *T) F() int {
+	t := self.n
+	return t
+}
+
+type U struct{}
+
+func (u U) A() {}
+
+func (u *U) B() {}
+
+func (_ *U) C() {}
+
+func (*U) D() {}
+
+type G[E any] struct{ e E }
+
+func (g G[E]) A() E { return g.e }
+
+func (g G[E]) B() E { return g.e }
+
+func (g *G[E]) C() E { return g.e }
+`,
+		},
+		{
+			name: "UnifyReceiverNamesDisabled",
+			in: `package p
+
+func (t *T) A() {}
+
+func (t *T) B() {}
+
+func (self *T) C() { println(self) }
+`,
+			want: `package p
+
+func (t *T) A() {}
+
+func (t *T) B() {}
+
+func (self *T) C() { println(self) }
+`,
+		},
+		{
+			name: "DeclSpacingMultiline",
+			opts: format.Options{DeclSpacing: format.DeclSpacingMultiline},
+			in: `package p
+
+func f1() {}
+func f2() {}
+func f3() {
+	println()
+}
+func f4() {
+	println()
+}
+// f5 is documented.
+func f5() {}
+`,
+			want: `package p
+
+func f1() {}
+func f2() {}
+func f3() {
+	println()
+}
+
+func f4() {
+	println()
+}
+
+// f5 is documented.
+func f5() {}
+`,
+		},
+		{
+			name: "DeclSpacingAll",
+			opts: format.Options{DeclSpacing: format.DeclSpacingAll},
+			in: `package p
+
+func f1() {}
+func f2() {}
+func f3() {
+	println()
+}
+func f4() {
+	println()
+}
+// f5 is documented.
+func f5() {}
+`,
+			want: `package p
+
+func f1() {}
+
+func f2() {}
+
+func f3() {
+	println()
+}
+
+func f4() {
+	println()
+}
+
+// f5 is documented.
+func f5() {}
+`,
+		},
+		{
+			name: "DeclSpacingNone",
+			opts: format.Options{DeclSpacing: format.DeclSpacingNone},
+			in: `package p
+
+func f1() {}
+func f2() {}
+func f3() {
+	println()
+}
+func f4() {
+	println()
+}
+// f5 is documented.
+func f5() {}
+`,
+			want: `package p
+
+func f1() {}
+func f2() {}
+func f3() {
+	println()
+}
+func f4() {
+	println()
+}
+
+// f5 is documented.
+func f5() {}
+`,
+		},
+		{
+			name: "SeparateFuncDecls",
+			opts: format.Options{SeparateFuncDecls: true},
+			in: `package p
+
+func f1() {}
+func f2() {}
+func (T) M1() {}
+func (t *T) M2() { t.M1() }
+// M3 is documented.
+func (T) M3() {}
+func (l List[E]) Len() int { return len(l) }
+func (p *Pair[K, V]) Key() K { return p.k }
+var x int
+func f3() {}
+type T struct{}
+func (T) M4() {}
+`,
+			want: `package p
+
+func f1() {}
+
+func f2() {}
+
+func (T) M1() {}
+
+func (t *T) M2() { t.M1() }
+
+// M3 is documented.
+func (T) M3() {}
+
+func (l List[E]) Len() int { return len(l) }
+
+func (p *Pair[K, V]) Key() K { return p.k }
+
+var x int
+
+func f3() {}
+
+type T struct{}
+
+func (T) M4() {}
+`,
+		},
+		{
+			name: "SeparateFuncDeclsDisabled",
+			in: `package p
+
+func f1() {}
+func (T) M1() {}
+`,
+			want: `package p
+
+func f1()     {}
+func (T) M1() {}
+`,
+		},
+		{
+			name: "IndentTabs",
+			in: `package p
+
+import (
+	"os"
+	"fmt"
+)
+
+type T struct {
+	A int // a
+	Bcd string // bcd
+}
+
+func f() {
+	if true {
+		println(` + "`raw\n\ttab`" + `)
+	}
+}
+`,
+			want: `package p
+
+import (
+	"fmt"
+	"os"
+)
+
+type T struct {
+	A   int    // a
+	Bcd string // bcd
+}
+
+func f() {
+	if true {
+		println(` + "`raw\n\ttab`" + `)
+	}
+}
+`,
+		},
+		{
+			name: "IndentSpaces",
+			opts: format.Options{IndentStyle: format.IndentStyle{Spaces: 4}},
+			in: `package p
+
+import (
+	"os"
+	"fmt"
+)
+
+type T struct {
+	A int // a
+	Bcd string // bcd
+}
+
+func f() {
+	if true {
+		println(` + "`raw\n\ttab`" + `)
+	}
+}
+`,
+			want: `package p
+
+import (
+    "fmt"
+    "os"
+)
+
+type T struct {
+    A   int    // a
+    Bcd string // bcd
+}
+
+func f() {
+    if true {
+        println(` + "`raw\n\ttab`" + `)
+    }
+}
+`,
+		},
+		{
+			name: "SortNonStdImports",
+			in: `package p
+
+import (
+	"os"
+	"foo.com/zzz"
+	"foo.com/aaa"
+	"fmt"
+
+	_ "foo.com/second"
+	_ "foo.com/first"
+	"bytes"
+)
+`,
+			want: `package p
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"foo.com/aaa"
+	"foo.com/zzz"
+
+	_ "foo.com/first"
+	_ "foo.com/second"
+)
+`,
+		},
+		{
+			name: "PreserveNonStdImportOrder",
+			opts: format.Options{PreserveNonStdImportOrder: true},
+			in: `package p
+
+import (
+	"os"
+	"foo.com/zzz"
+	"foo.com/aaa"
+	"fmt"
+
+	_ "foo.com/second"
+	_ "foo.com/first"
+	"bytes"
+)
+`,
+			want: `package p
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"foo.com/zzz"
+	"foo.com/aaa"
+
+	_ "foo.com/second"
+	_ "foo.com/first"
+)
+`,
+		},
+		{
+			name: "LocalPrefixes",
+			opts: format.Options{LocalPrefixes: []string{"example.com/mymod"}},
+			in: `package p
+
+import (
+	"example.com/mymod/zz"
+	. "example.com/mymod/dot"
+	"fmt"
+	mod "example.com/mymod"
+
+	"github.com/foo/bar"
+	_ "example.com/mymodule"
+)
+`,
+			want: `package p
+
+import (
+	"fmt"
+
+	_ "example.com/mymodule"
+	"github.com/foo/bar"
+
+	mod "example.com/mymod"
+	. "example.com/mymod/dot"
+	"example.com/mymod/zz"
+)
+`,
+		},
+		{
+			name: "LocalPrefixesLast",
+			opts: format.Options{LocalPrefixes: []string{"example.com/mymod/"}},
+			in: `package p
+
+import (
+	"os"
+
+	"github.com/foo/bar"
+	. "example.com/mymod/dot"
+	mod "example.com/mymod"
+)
+`,
+			want: `package p
+
+import (
+	"os"
+
+	"github.com/foo/bar"
+
+	mod "example.com/mymod"
+	. "example.com/mymod/dot"
+)
+`,
+		},
+		{
+			name: "TrailingCommas",
+			opts: format.Options{TrailingCommas: true},
+			in: `package p
+
+func f() {
+	fmt.Printf("%s: %d\n",
+		name, count)
+	g(
+		first,
+		second)
+	h(first,
+		second...) // a comment
+	run(func() {
+		println()
+	})
+	done(a, b,
+		c,
+	)
+	short(a, b)
+}
+
+func Map[K comparable,
+	V any](m map[K]V) {
+}
+
+type Pair[
+	A any,
+	B any] struct{}
+`,
+			want: `package p
+
+func f() {
+	fmt.Printf("%s: %d\n",
+		name, count,
+	)
+	g(
+		first,
+		second,
+	)
+	h(first,
+		second...,
+	) // a comment
+	run(func() {
+		println()
+	})
+	done(a, b,
+		c,
+	)
+	short(a, b)
+}
+
+func Map[K comparable,
+	V any,
+](m map[K]V) {
+}
+
+type Pair[
+	A any,
+	B any,
+] struct{}
+`,
+		},
+		{
+			name: "FlattenElse",
+			opts: format.Options{FlattenElse: true, AssertSemanticEquivalence: true},
+			in: `package p
+
+func f(x int) int {
+	if x < 0 {
+		return -1
+	} else {
+		println("positive")
+	}
+	for {
+		if x > 10 {
+			break
+		} else {
+			x++
+		}
+	}
+	if x == 0 {
+		panic("zero")
+	} else {
+		if x == 1 {
+			return 1
+		} else {
+			// a comment
+			x *= 2
+		}
+	}
+	return x
+}
+
+func nested(s string) string {
+	if s == "" {
+		return "empty"
+	} else if s == "a" {
+		return "a"
+	} else {
+		return s
+	}
+}
+
+func scoped(n int) int {
+	if n > 0 {
+		return n
+	} else {
+		n := -n
+		println(n)
+	}
+	err := g()
+	if err != nil {
+		return 0
+	} else {
+		err := h()
+		println(err)
+	}
+	if x := n * 2; x > 10 {
+		return x
+	} else {
+		println(x)
+	}
+	if n == 3 {
+		return 3
+	} else { // kept
+		println(n)
+	}
+	if n == 4 {
+		println(n)
+	} else {
+		println("not 4")
+	}
+	return 0
+}
+
+func shadow() {
+	// Even if they're in another scope, names used elsewhere aren't moved.
+	if cond() {
+		return
+	} else {
+		v := 1
+		println(v)
+	}
+	func() {
+		if cond() {
+			return
+		} else {
+			v := 2
+			println(v)
+		}
+	}()
+}
+`,
+			want: `package p
+
+func f(x int) int {
+	if x < 0 {
+		return -1
+	}
+	println("positive")
+	for {
+		if x > 10 {
+			break
+		}
+		x++
+	}
+	if x == 0 {
+		panic("zero")
+	}
+	if x == 1 {
+		return 1
+	}
+	// a comment
+	x *= 2
+	return x
+}
+
+func nested(s string) string {
+	if s == "" {
+		return "empty"
+	} else if s == "a" {
+		return "a"
+	} else {
+		return s
+	}
+}
+
+func scoped(n int) int {
+	if n > 0 {
+		return n
+	} else {
+		n := -n
+		println(n)
+	}
+	err := g()
+	if err != nil {
+		return 0
+	} else {
+		err := h()
+		println(err)
+	}
+	if x := n * 2; x > 10 {
+		return x
+	} else {
+		println(x)
+	}
+	if n == 3 {
+		return 3
+	} else { // kept
+		println(n)
+	}
+	if n == 4 {
+		println(n)
+	} else {
+		println("not 4")
+	}
+	return 0
+}
+
+func shadow() {
+	// Even if they're in another scope, names used elsewhere aren't moved.
+	if cond() {
+		return
+	} else {
+		v := 1
+		println(v)
+	}
+	func() {
+		if cond() {
+			return
+		}
+		v := 2
+		println(v)
+	}()
+}
+`,
+		},
+		{
+			name: "NormalizeImportComments",
+			opts: format.Options{NormalizeImportComments: true},
+			in: `package p
+
+import (
+	"fmt"
+	_ "net/http/pprof" /* debug handlers */
+	// for go:embed
+	_ "embed"
+
+	// register the driver
+	_ "github.com/lib/pq"
+	. "github.com/onsi/gomega" //    matchers
+	_ "github.com/foo/bar"
+	_ "github.com/foo/baz" //nolint:foo
+)
+`,
+			want: `package p
+
+import (
+	_ "embed" // for go:embed
+	"fmt"
+	_ "net/http/pprof" // debug handlers
+
+	_ "github.com/foo/bar"
+	_ "github.com/foo/baz"     //nolint:foo
+	_ "github.com/lib/pq"      // register the driver
+	. "github.com/onsi/gomega" // matchers
+)
+`,
+		},
+		{
+			name: "ImportCommentPlaceholder",
+			opts: format.Options{
+				NormalizeImportComments:  true,
+				ImportCommentPlaceholder: "TODO: explain why this import is needed",
+			},
+			in: `package p
+
+import (
+	"fmt"
+	_ "net/http/pprof"
+
+	_ "github.com/lib/pq" // register the driver
+	. "github.com/onsi/gomega"
+)
+
+// The doc comment explains this one.
+import _ "unsafe"
+`,
+			want: `package p
+
+import (
+	"fmt"
+	_ "net/http/pprof" // TODO: explain why this import is needed
+
+	_ "github.com/lib/pq"      // register the driver
+	. "github.com/onsi/gomega" // TODO: explain why this import is needed
+
+	// The doc comment explains this one.
+	_ "unsafe"
+)
+`,
+		},
+		{
+			name: "DisabledRules",
+			opts: format.Options{
+				LangVersion:   "1.13",
+				DisabledRules: []string{"octal-literals", "empty-block", "short-decl"},
+			},
+			in: `package p
+
+func f() {
+
+	var x = 0755
+
+	for {
+	}
+}
+`,
+			want: `package p
+
+func f() {
+	var x = 0755
+
+	for {
+	}
+}
+`,
+		},
+		{
+			name: "DisabledRulesCompositeNewlines",
+			opts: format.Options{DisabledRules: []string{"composite-newlines", "unknown"}},
+			in: `package p
+
+var _ = []int{1,
+	2}
+`,
+			want: `package p
+
+var _ = []int{1,
+	2}
+`,
+		},
+		{
+			name: "AlwaysParenthesizeImports",
+			opts: format.Options{AlwaysParenthesizeImports: true},
+			in: `package p
+
+import "fmt" // inline
+
+// #include <stdio.h>
+import "C"
+
+import "os"
+import "io"
+`,
+			want: `package p
+
+import (
+	"fmt" // inline
+)
+
+// #include <stdio.h>
+import "C"
+
+import (
+	"io"
+	"os"
+)
+`,
+		},
+		{
+			name: "AlwaysParenthesizeImportsMerged",
+			opts: format.Options{AlwaysParenthesizeImports: true},
+			in: `package p
+
+import "fmt"
+
+import (
+	"os"
+)
+`,
+			want: `package p
+
+import (
+	"fmt"
+	"os"
+)
+`,
+		},
+		{
+			name: "Simplify",
+			opts: format.Options{Simplify: true},
+			in: `package p
+
+const ()
+
+func f(s []int, m map[string][]int, ps []*T) {
+	_ = s[1:len(s)]
+	_ = s[0:len(s)]
+	_ = s[1:len(m)]
+	_ = [][]int{[]int{1}, []int{2}}
+	_ = map[T]T{T{1}: T{2}}
+	_ = []*T{&T{1}}
+	for i, _ = range s {
+	}
+	for _ = range s {
+	}
+	for _, _ = range s {
+	}
+}
+`,
+			want: `package p
+
+func f(s []int, m map[string][]int, ps []*T) {
+	_ = s[1:]
+	_ = s[0:]
+	_ = s[1:len(m)]
+	_ = [][]int{{1}, {2}}
+	_ = map[T]T{{1}: {2}}
+	_ = []*T{{1}}
+	for i = range s {
+	}
+	for range s {
+	}
+	for range s {
+	}
+}
+`,
+		},
+		{
+			name: "SimplifyDisabled",
+			in: `package p
+
+func f(s []int) {
+	_ = s[1:len(s)]
+	_ = [][]int{[]int{1}}
+	for _ = range s {
+	}
+}
+`,
+			want: `package p
+
+func f(s []int) {
+	_ = s[1:len(s)]
+	_ = [][]int{[]int{1}}
+	for _ = range s {
+	}
+}
+`,
+		},
+		{
+			name: "SimplifyDisabledRule",
+			opts: format.Options{Simplify: true, DisabledRules: []string{"simplify"}},
+			in: `package p
+
+func f(s []int) {
+	_ = s[1:len(s)]
+	_ = [][]int{[]int{1}}
+	for _ = range s {
+	}
+}
+`,
+			want: `package p
+
+func f(s []int) {
+	_ = s[1:len(s)]
+	_ = [][]int{[]int{1}}
+	for _ = range s {
+	}
+}
+`,
+		},
+		{
+			name: "UppercaseHexDigits",
+			opts: format.Options{LangVersion: "1.13", UppercaseHexDigits: true},
+			in: `package p
+
+const (
+	a = 0XffAB
+	b = 0xab_cd_EF
+	c = 0x1e5
+	d = 0x_ff
+
+	// Hexadecimal floats are uppercased too, rather than lowercased,
+	// so that all hexadecimal literals are consistent.
+	e = 0x1.fp3
+	f = 0X1.fP-2
+	g = 0Xa_b.cp1i
+
+	// Other literals are left alone.
+	h = 0b1010
+	i = 1e5
+	j = "0xff"
+)
+`,
+			want: `package p
+
+const (
+	a = 0xFFAB
+	b = 0xAB_CD_EF
+	c = 0x1E5
+	d = 0x_FF
+
+	// Hexadecimal floats are uppercased too, rather than lowercased,
+	// so that all hexadecimal literals are consistent.
+	e = 0x1.Fp3
+	f = 0x1.Fp-2
+	g = 0xA_B.Cp1i
+
+	// Other literals are left alone.
+	h = 0b1010
+	i = 1e5
+	j = "0xff"
+)
+`,
+		},
+		{
+			name: "NormalizeTags",
+			opts: format.Options{NormalizeTags: true},
+			in: `package p
+
+type T struct {
+	A int ` + "`" + `json:"a"    xml:"b"` + "`" + `
+	B int ` + "`" + `  json:"b,omitempty" ` + "`" + `
+	C int "json:\"c\"   xml:\"c\""
+	D int ` + "`" + `json:"d" custom:"x y\" z"  yaml:"d"` + "`" + `
+	E int ` + "`" + `json:"e"` + "`" + `
+
+	// Malformed tags are left alone.
+	F int ` + "`" + `json:"f"xml:"f"` + "`" + `
+	G int ` + "`" + `json  xml:"g"` + "`" + `
+	H int ` + "`" + `json:"h	xml:"h"` + "`" + `
+	I int ` + "`" + `json:"i"	xml:"i"` + "`" + `
+}
+`,
+			want: `package p
+
+type T struct {
+	A int ` + "`" + `json:"a" xml:"b"` + "`" + `
+	B int ` + "`" + `json:"b,omitempty"` + "`" + `
+	C int "json:\"c\" xml:\"c\""
+	D int ` + "`" + `json:"d" custom:"x y\" z" yaml:"d"` + "`" + `
+	E int ` + "`" + `json:"e"` + "`" + `
+
+	// Malformed tags are left alone.
+	F int ` + "`" + `json:"f"xml:"f"` + "`" + `
+	G int ` + "`" + `json  xml:"g"` + "`" + `
+	H int ` + "`" + `json:"h	xml:"h"` + "`" + `
+	I int ` + "`" + `json:"i"	xml:"i"` + "`" + `
+}
+`,
+		},
+		{
+			name: "NormalizeTagsDisabled",
+			in: `package p
+
+type T struct {
+	A int ` + "`" + `json:"a"    xml:"b"` + "`" + `
+}
+`,
+			want: `package p
+
+type T struct {
+	A int ` + "`" + `json:"a"    xml:"b"` + "`" + `
+}
+`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := format.Source([]byte(test.in), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+var rxStampVersion = regexp.MustCompile(`(?m)^//gofumpt:version .*$`)
+
+func TestStampHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "Fresh",
+			in: `// Package p does things.
+package p
+`,
+			want: `//gofumpt:version X
+
+// Package p does things.
+package p
+`,
+		},
+		{
+			name: "BuildConstraints",
+			in: `//go:build linux
+// +build linux
+
+package p
+`,
+			want: `//go:build linux
+// +build linux
+
+//gofumpt:version X
+
+package p
+`,
+		},
+		{
+			name: "License",
+			in: `// Copyright (c) 2021, The Authors.
+// See LICENSE for licensing information
+
+// Package p does things.
+package p
+`,
+			want: `// Copyright (c) 2021, The Authors.
+// See LICENSE for licensing information
+
+//gofumpt:version X
+
+// Package p does things.
+package p
+`,
+		},
+		{
+			name: "LicenseBlockComment",
+			in: `/*
+Copyright (c) 2021, The Authors.
+
+See LICENSE for licensing information.
+*/
+
+/*
+Package p does things.
+
+It has a block doc comment.
+*/
+package p
+`,
+			want: `/*
+Copyright (c) 2021, The Authors.
+
+See LICENSE for licensing information.
+*/
+
+//gofumpt:version X
+
+/*
+Package p does things.
+
+It has a block doc comment.
+*/
+package p
+`,
+		},
+		{
+			name: "Update",
+			in: `//go:build linux
+// +build linux
+
+//gofumpt:version v0.0.1
+
+// Package p does things.
+package p
+`,
+			want: `//go:build linux
+// +build linux
+
+//gofumpt:version X
+
+// Package p does things.
+package p
+`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			opts := format.Options{StampHeader: true}
+			got, err := format.Source([]byte(test.in), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Formatting twice must not add a second stamp.
+			got, err = format.Source(got, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = rxStampVersion.ReplaceAll(got, []byte("//gofumpt:version X"))
+			if string(got) != test.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatterReset(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+var (
+	foo = "bar"
+)
+`)
+	want := `package p
+
+var foo = "bar"
+`
+	var f format.Formatter
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 50; j++ {
+			got, err := f.Format("foo.go", in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+			}
+		}
+		f.Reset()
+	}
+}
+
+func TestMaxInputBytes(t *testing.T) {
+	t.Parallel()
+
+	in := []byte("package p\n\nvar (\n\tfoo = 1\n)\n")
+	want := "package p\n\nvar foo = 1\n"
+
+	got, err := format.Source(in, format.Options{MaxInputBytes: len(in)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	opts := format.Options{MaxInputBytes: len(in) - 1}
+	wantErr := fmt.Sprintf("input is %d bytes, larger than the limit of %d bytes", len(in), len(in)-1)
+	if _, err := format.Source(in, opts); err == nil || err.Error() != wantErr {
+		t.Fatalf("Source got error %v, want %q", err, wantErr)
+	}
+	f := format.Formatter{Options: opts}
+	if _, err := f.Format("foo.go", in); err == nil || err.Error() != wantErr {
+		t.Fatalf("Format got error %v, want %q", err, wantErr)
+	}
+}
+
+func TestSourceFile(t *testing.T) {
+	t.Parallel()
+
+	in := []byte("package p\n\nvar (\n\tfoo = 1\n)\n")
+	want := "package p\n\nvar foo = 1\n"
+	got, err := format.SourceFile("foo.go", in, format.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	_, err = format.SourceFile("foo.go", []byte("package p\n\nfunc f() {\n\tx :=\n}\n"), format.Options{})
+	if err == nil || !strings.HasPrefix(err.Error(), "foo.go:5:1: ") {
+		t.Fatalf("got error %v, want one at foo.go:5:1", err)
+	}
+}
+
+func TestFragment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "Expression",
+			in:   "[]int{1,\n2}",
+			want: "[]int{\n\t1,\n\t2,\n}",
+		},
+		{
+			name: "Statements",
+			in: `
+	var x = 0755
+
+	if x > 0 {
+
+		println(x)
+	}
+`,
+			want: `
+	x := 0o755
+
+	if x > 0 {
+		println(x)
+	}
+`,
+		},
+		{
+			name: "Methods",
+			in: `func (t T) A() {
+
+	t.B()
+}
+func (t T) B() (
+	int,
+) {
+	return 0
+}`,
+			want: `func (t T) A() {
+	t.B()
+}
+
+func (t T) B() int {
+	return 0
+}`,
+		},
+		{
+			name: "File",
+			in:   "package p\n\nvar (\n\tx = 1\n)\n",
+			want: "package p\n\nvar x = 1\n",
+		},
+		{
+			name: "Space",
+			in:   " \n\t",
+			want: " \n\t",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := format.Fragment([]byte(test.in), format.Options{LangVersion: "1.13"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got:\n%q\nwant:\n%q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := format.Fragment([]byte("x :="), format.Options{}); err == nil {
+		t.Fatal("want an error for an invalid fragment")
+	}
+
+	// Only complete files are stamped.
+	opts := format.Options{StampHeader: true}
+	got, err := format.Fragment([]byte("package p\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = rxStampVersion.ReplaceAll(got, []byte("//gofumpt:version X"))
+	if want := "//gofumpt:version X\n\npackage p\n"; string(got) != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+	got, err = format.Fragment([]byte("var x = 1\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "var x = 1\n"; string(got) != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestDetectLangVersion(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"mod/go.mod":         "module test.com/mod\n\ngo 1.16\n",
+		"mod/p.go":           "package p\n\nconst perm = 0755\n",
+		"mod/sub/deep/p.go":  "package deep\n",
+		"nogo/go.mod":        "module test.com/nogo\n",
+		"prerelease/go.mod":  "module test.com/prerelease\n\ngo 1.21rc1\n",
+		"invalid/go.mod":     "module test.com/invalid\n\ngo 1.16 1.17\n",
+		"nomod/sub/.gitkeep": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{dir: "mod", want: "1.16"},
+		{dir: "mod/sub/deep", want: "1.16"},
+		{dir: "nogo", want: "v1"},
+		{dir: "prerelease", want: "1.21"},
+		{dir: "invalid", wantErr: true},
+		// Note that this relies on the temporary directory not being
+		// inside a Go module.
+		{dir: "nomod/sub", want: "v1"},
+	}
+	for _, test := range tests {
+		got, err := format.DetectLangVersion(filepath.Join(dir, test.dir))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: want an error", test.dir)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.dir, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.dir, got, test.want)
+		}
+	}
+
+	// The option only applies when given a filename.
+	filename := filepath.Join(dir, "mod", "p.go")
+	src := []byte(files["mod/p.go"])
+	for _, test := range []struct {
+		filename string
+		opts     format.Options
+		want     string
+	}{
+		{filename, format.Options{DetectLangVersion: true}, "package p\n\nconst perm = 0o755\n"},
+		{filename, format.Options{}, "package p\n\nconst perm = 0755\n"},
+		{filename, format.Options{DetectLangVersion: true, LangVersion: "1.12"}, "package p\n\nconst perm = 0755\n"},
+		{"", format.Options{DetectLangVersion: true}, "package p\n\nconst perm = 0755\n"},
+	} {
+		got, err := format.SourceFile(test.filename, src, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("SourceFile(%q) with %+v got:\n%s\nwant:\n%s", test.filename, test.opts, got, test.want)
+		}
+	}
+}
+
+func TestFormatPackages(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":    "module test.com/p\n\ngo 1.13\n",
+		"p.go":      "package p\n\nvar (\n\tperm = 0755\n)\n",
+		"p_test.go": "package p\n\nimport \"testing\"\n\nfunc TestP(t *testing.T) {\n\n\tprintln(perm)\n}\n",
+		"sub/q.go":  "package sub\n\nfunc f() {\n\n}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedModule,
+		Dir:   dir,
+		Fset:  token.NewFileSet(),
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := format.FormatPackages(cfg.Fset, pkgs, format.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"p.go":      "package p\n\nvar perm = 0o755\n",
+		"p_test.go": "package p\n\nimport \"testing\"\n\nfunc TestP(t *testing.T) {\n\tprintln(perm)\n}\n",
+		"sub/q.go":  "package sub\n\nfunc f() {\n}\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files, want %d", len(got), len(want))
+	}
+	for name, content := range want {
+		res, ok := got[filepath.Join(dir, filepath.FromSlash(name))]
+		if !ok {
+			t.Fatalf("%s was not formatted", name)
+		}
+		if string(res) != content {
+			t.Fatalf("%s got:\n%s\nwant:\n%s", name, res, content)
+		}
+	}
+}
+
+func TestFileNumbers(t *testing.T) {
+	t.Parallel()
+
+	in := `package p
+
+const (
+	a = 1.5E3i
+	b = 0123i
+	c = 0X1P-2
+	d = 0XFF
+)
+`
+	want := `package p
+
+const (
+	a = 1.5e3i
+	b = 123i
+	c = 0x1p-2
+	d = 0xFF
+)
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", in, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format.File(fset, file, format.Options{})
+
+	// Unlike go/format, go/printer doesn't normalize number literals by
+	// itself, so File must have done it.
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&buf, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGofumptOnlyDiff(t *testing.T) {
+	t.Parallel()
+
+	// Both gofmt and gofumpt fix the indentation and spacing, but only
+	// gofumpt removes the empty lines and groups the std imports.
+	in := []byte(`package p
+
+import (
+	"foo.com/bar"
+
+	"io"
+)
+
+func f() {
+
+     println( "foo" )
+}
+`)
+	got, err := format.GofumptOnlyDiff(in, format.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []format.Hunk{
+		{OldStart: 4, NewStart: 4, Old: []string{"\t\"foo.com/bar\"\n", "\n"}},
+		{OldStart: 7, NewStart: 5, New: []string{"\n", "\t\"foo.com/bar\"\n"}},
+		{OldStart: 10, NewStart: 10, Old: []string{"\n"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:\n%#v\nwant:\n%#v", got, want)
+	}
+
+	// Formatting the output again results in no differences.
+	got, err = format.GofumptOnlyDiff([]byte("package p\n\nfunc f() {\n\tprintln(\"foo\")\n}\n"), format.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatalf("got %#v, want no hunks", got)
+	}
+}
+
+func TestTextEdits(t *testing.T) {
+	t.Parallel()
+
+	for _, in := range []string{
+		"package p\n\nfunc f() {\n\n\tprintln(\"héllo, 世界\")\n}\n",
+		"package p\n\nvar (\n\tfoo = \"bar\"\n)\n\nvar s = \"😀\" // no newline at the end",
+		"package p\n",
+		"package p",
+	} {
+		want, err := format.Source([]byte(in), format.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, enc := range []format.PositionEncoding{format.PositionUTF16, format.PositionUTF8} {
+			edits, err := format.TextEdits([]byte(in), enc, format.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := applyTextEdits(in, edits, enc); got != string(want) {
+				t.Fatalf("applying %#v to %q:\ngot:\n%s\nwant:\n%s", edits, in, got, want)
+			}
+		}
+	}
+
+	// The edit ends after non-ASCII text, which takes a different number
+	// of units in each encoding.
+	in := []byte("package p\n\nvar s = \"é😀\"")
+	for _, test := range []struct {
+		enc  format.PositionEncoding
+		want int
+	}{
+		{format.PositionUTF16, 13},
+		{format.PositionUTF8, 16},
+	} {
+		edits, err := format.TextEdits(in, test.enc, format.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []format.TextEdit{{
+			Start:   format.TextPosition{Line: 2},
+			End:     format.TextPosition{Line: 2, Character: test.want},
+			NewText: "var s = \"é😀\"\n",
+		}}
+		if !reflect.DeepEqual(edits, want) {
+			t.Fatalf("got:\n%#v\nwant:\n%#v", edits, want)
+		}
+	}
+}
+
+// applyTextEdits applies LSP-style edits to src, converting their positions
+// into byte offsets following enc.
+func applyTextEdits(src string, edits []format.TextEdit, enc format.PositionEncoding) string {
+	offset := func(pos format.TextPosition) int {
+		lines := strings.SplitAfter(src, "\n")
+		off := 0
+		for _, line := range lines[:pos.Line] {
+			off += len(line)
+		}
+		if enc == format.PositionUTF8 {
+			return off + pos.Character
+		}
+		units := 0
+		for i, r := range lines[pos.Line] {
+			if units >= pos.Character {
+				return off + i
+			}
+			units += len(utf16.Encode([]rune{r}))
+		}
+		return off + len(lines[pos.Line])
+	}
+	// Apply the edits from the end, so that positions stay valid.
+	for i := len(edits) - 1; i >= 0; i-- {
+		edit := edits[i]
+		start, end := offset(edit.Start), offset(edit.End)
+		src = src[:start] + edit.NewText + src[end:]
+	}
+	return src
+}
+
+func TestSourceRange(t *testing.T) {
+	t.Parallel()
+
+	in := "package p\n\nfunc f() {\n\n\tprintln( 1 )\n}\n\nfunc g() {\n\n\tprintln( 2 )\n}\n"
+	gStart := strings.Index(in, "func g")
+	for _, test := range []struct {
+		name       string
+		in         string // defaults to in
+		start, end int
+		want       string
+	}{
+		{
+			name:  "Whole",
+			start: 0,
+			end:   len(in),
+			want:  "package p\n\nfunc f() {\n\tprintln(1)\n}\n\nfunc g() {\n\tprintln(2)\n}\n",
+		},
+		{
+			name:  "SecondFunc",
+			start: gStart,
+			end:   len(in),
+			want:  "package p\n\nfunc f() {\n\n\tprintln( 1 )\n}\n\nfunc g() {\n\tprintln(2)\n}\n",
+		},
+		{
+			name:  "Cursor",
+			start: strings.Index(in, "( 2"),
+			end:   strings.Index(in, "( 2"),
+			// The empty line is removed too, as part of the same hunk.
+			want: "package p\n\nfunc f() {\n\n\tprintln( 1 )\n}\n\nfunc g() {\n\tprintln(2)\n}\n",
+		},
+		{
+			name:  "Unchanged",
+			start: 0,
+			end:   len("package p\n"),
+			want:  in,
+		},
+		{
+			name: "BoundaryOverlap",
+			// Only the empty line in g is in the range, but the
+			// change to the next line is in the same hunk.
+			start: strings.Index(in, "\n\n\tprintln( 2") + 1,
+			end:   strings.Index(in, "\tprintln( 2"),
+			want:  "package p\n\nfunc f() {\n\n\tprintln( 1 )\n}\n\nfunc g() {\n\tprintln(2)\n}\n",
+		},
+		{
+			name: "BoundaryTouch",
+			// The range ends right where the hunk starts.
+			start: gStart,
+			end:   strings.Index(in, "\n\n\tprintln( 2") + 1,
+			want:  in,
+		},
+		{
+			name: "AdjacentLines",
+			in:   "package p\n\nfunc f() {\n\tprintln( 1 )\n\tprintln( 2 )\n}\n",
+			// Only the first call is in the range, but the second
+			// is on the next line, so it's in the same hunk.
+			start: len("package p\n\nfunc f() {\n"),
+			end:   len("package p\n\nfunc f() {\n\tprintln( 1 )\n"),
+			want:  "package p\n\nfunc f() {\n\tprintln(1)\n\tprintln(2)\n}\n",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			src := in
+			if test.in != "" {
+				src = test.in
+			}
+			got, err := format.SourceRange([]byte(src), test.start, test.end, format.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+
+	if _, err := format.SourceRange([]byte(in), 3, 2, format.Options{}); err == nil {
+		t.Fatal("expected an error for an invalid range")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	in := "package p\n\nfunc f() {\n\n\tprintln( 1 )\n}\n\nfunc g() {}\n\nfunc h() {}\n\nfunc i() {}\n\nfunc j() {\n\tprintln(2)\n}\nvar x = 3"
+	want := `--- a/foo.go
++++ b/foo.go
+@@ -1,8 +1,7 @@
+ package p
+ 
+ func f() {
+-
+-	println( 1 )
++	println(1)
+ }
+ 
+ func g() {}
+@@ -14,4 +13,5 @@
+ func j() {
+ 	println(2)
+ }
+-var x = 3
+\ No newline at end of file
++
++var x = 3
+`
+	// The output is the same every time.
+	for i := 0; i < 3; i++ {
+		got, err := format.Diff("foo.go", []byte(in), format.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+
+	// Formatted source results in no diff.
+	got, err := format.Diff("foo.go", []byte("package p\n"), format.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatalf("got %q, want no diff", got)
+	}
+}
+
+func TestVerifyOutput(t *testing.T) {
+	// Not parallel, as the test hook affects all calls to Source.
+
+	// A buggy rule which replaces a literal with invalid code.
+	restore := format.SetTestHookFile(func(file *ast.File) {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if lit, ok := node.(*ast.BasicLit); ok && lit.Kind == token.INT {
+				lit.Value = "1 +"
+			}
+			return true
+		})
+	})
+	defer restore()
+
+	in := []byte("package p\n\nvar x = 1\n")
+	if _, err := format.Source(in, format.Options{}); err != nil {
+		t.Fatalf("invalid output should only be caught with VerifyOutput: %v", err)
+	}
+	_, err := format.Source(in, format.Options{VerifyOutput: true})
+	if err == nil || !strings.HasPrefix(err.Error(), "formatting produced invalid Go: ") {
+		t.Fatalf("got error %v, want an invalid output error", err)
+	}
+}
+
+func TestAssertSemanticEquivalence(t *testing.T) {
+	// Not parallel, as the test hook affects all calls to Source.
+
+	in := []byte(`package p
+
+import "foo.com/bar"
+
+import (
+	"os"
+
+	"io"
+)
+
+var a = 0755
+var b, c int = 0X1P-2, 2
+var d = int(int(a))
+
+func f(x int, y int) {
+
+	var s = "foo"
+	for true {
+		println(s, x, y, ` + "`raw`" + `)
+	}
+	_ = [][]int{[]int{x}}
+	if x > 0 {
+		return
+	} else {
+	}
+}
+
+var _, _, _ = bar.X, os.Args, io.EOF
+`)
+	opts := format.Options{
+		LangVersion:               "1.13",
+		ExtraRules:                true,
+		Simplify:                  true,
+		AssertSemanticEquivalence: true,
+	}
+	if _, err := format.Source(in, opts); err != nil {
+		t.Fatalf("formatting should keep the meaning of the program: %v", err)
+	}
+
+	// A buggy rule which changes the value of a literal.
+	restore := format.SetTestHookFile(func(file *ast.File) {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if lit, ok := node.(*ast.BasicLit); ok && lit.Value == "2" {
+				lit.Value = "3"
+			}
+			return true
+		})
+	})
+	defer restore()
+
+	if _, err := format.Source(in, format.Options{}); err != nil {
+		t.Fatalf("semantic changes should only be caught with AssertSemanticEquivalence: %v", err)
+	}
+	_, err := format.Source(in, opts)
+	if err == nil || !strings.HasPrefix(err.Error(), "formatting changed the syntax tree") {
+		t.Fatalf("got error %v, want a syntax tree change error", err)
+	}
+}
+
+func TestDeclHook(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+import "os"
+
+var a = 1
+var b = 2
+
+func unchanged() {
+	println()
+}
+
+func comment() {
+	//no space
+	println()
+}
+
+func body() {
+
+	println()
+}
+
+type T struct{}
+`)
+	var got []string
+	opts := format.Options{DeclHook: func(decl ast.Decl, changed bool) {
+		var name string
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			name = decl.Tok.String()
+		case *ast.FuncDecl:
+			name = decl.Name.Name
+		}
+		got = append(got, fmt.Sprintf("%s %v", name, changed))
+	}}
+	if _, err := format.Source(in, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"import false",
+		"var true", // a and b are joined
+		"unchanged false",
+		"comment true",
+		"body true",
+		"type false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestSourceWithReport(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+import (
+	"foo.com/bar"
+
+	"io"
+)
+
+var a = 0755
+var b = 2
+
+func f() {
+
+	//no space
+	var x = 3
+	for true {
+		println(x)
+	}
+}
+`)
+	opts := format.Options{LangVersion: "1.13"}
+	res, changes, err := format.SourceWithReport(in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := format.Source(in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != string(want) {
+		t.Fatalf("got:\n%s\nwant:\n%s", res, want)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, fmt.Sprintf("%s %s", change.Pos, change.Rule))
+	}
+	wantChanges := []string{
+		"4:2 std-imports",
+		"6:2 std-imports",
+		"9:9 octal-literals",
+		"10:1 decl-group",
+		"13:1 block-empty-lines",
+		"14:2 comment-spacing",
+		"15:2 short-decl",
+		"16:6 infinite-loop",
+	}
+	if !reflect.DeepEqual(got, wantChanges) {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, wantChanges)
+	}
+	for _, change := range changes {
+		if change.Message == "" {
+			t.Errorf("change %v has no message", change)
+		}
+	}
+
+	// Formatting the output again results in no changes.
+	_, changes, err = format.SourceWithReport(res, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes != nil {
+		t.Fatalf("got %v, want no changes", changes)
+	}
+}
+
+func TestSourceWithReportImports(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+import (
+	"os"
+	"example.com/mymod/zz"
+	"fmt"
+
+	"github.com/foo/bar"
+)
+`)
+	for _, test := range []struct {
+		disabled string
+		want     []string
+	}{
+		{"std-imports", []string{"5:1 local-imports", "5:2 local-imports", "8:22 local-imports"}},
+		{"local-imports", []string{"6:2 std-imports"}},
+	} {
+		opts := format.Options{
+			LocalPrefixes: []string{"example.com/mymod"},
+			DisabledRules: []string{test.disabled},
+		}
+		_, changes, err := format.SourceWithReport(in, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, change := range changes {
+			got = append(got, fmt.Sprintf("%s %s", change.Pos, change.Rule))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("with %s disabled, got:\n%q\nwant:\n%q", test.disabled, got, test.want)
+		}
+	}
+}
+
+func TestSimplifyChanges(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`package p
+
+func unchanged(s []int) {
+	_ = s[1:]
+}
+
+func slice(s []int) {
+	_ = s[1:len(s)]
+}
+
+func lit() {
+	_ = [][]int{[]int{1}}
+}
+`)
+	var got []string
+	opts := format.Options{Simplify: true, DeclHook: func(decl ast.Decl, changed bool) {
+		got = append(got, fmt.Sprintf("%s %v", decl.(*ast.FuncDecl).Name.Name, changed))
+	}}
+	_, changes, err := format.SourceWithReport(in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"unchanged false",
+		"slice true",
+		"lit true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+	got = nil
+	for _, change := range changes {
+		got = append(got, fmt.Sprintf("%s %s", change.Pos, change.Rule))
+	}
+	wantChanges := []string{
+		"8:10 simplify",
+		"12:14 simplify",
+	}
+	if !reflect.DeepEqual(got, wantChanges) {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, wantChanges)
+	}
+
+	// With the rule disabled, nothing changes.
+	got = nil
+	opts.DisabledRules = []string{"simplify"}
+	res, changes, err := format.SourceWithReport(in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != string(in) {
+		t.Fatalf("got:\n%s\nwant:\n%s", res, in)
+	}
+	if changes != nil {
+		t.Fatalf("got %v, want no changes", changes)
+	}
+	want = []string{
+		"unchanged false",
+		"slice false",
+		"lit false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func BenchmarkSource(b *testing.B) {
+	// A large file with many composite literals, each of which needs
+	// newlines to be added.
+	var buf bytes.Buffer
+	buf.WriteString("package p\n\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&buf, "var v%d = []T{{1,\n\t2}, {3,\n\t4}, {5, 6}}\n\n", i)
+	}
+	src := buf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		if _, err := format.Source(src, format.Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFormatter(b *testing.B) {
+	// Many small files, like when formatting a whole module.
+	var srcs [][]byte
+	for i := 0; i < 50; i++ {
+		srcs = append(srcs, []byte(fmt.Sprintf(`package p
+
+import (
+	"foo.com/bar"
+	"os"
+)
+
+var (
+	v%d = 0755
+)
+
+func f%d() {
+	var x = bar.X
+	println(x, os.Args)
+}
+`, i, i)))
+	}
+
+	b.Run("Source", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, src := range srcs {
+				if _, err := format.Source(src, format.Options{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Formatter", func(b *testing.B) {
+		b.ReportAllocs()
+		var f format.Formatter
+		for i := 0; i < b.N; i++ {
+			for _, src := range srcs {
+				if _, err := f.Format("foo.go", src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkSourceImports(b *testing.B) {
+	// A large import block with std imports after the non-std ones, so
+	// that the std imports need to be moved to the top.
+	var buf bytes.Buffer
+	buf.WriteString("package p\n\nimport (\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, "\t\"foo.com/pkg%d\"\n", i)
+	}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, "\t\"std%d\"\n", i)
+	}
+	buf.WriteString(")\n")
+	src := buf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		if _, err := format.Source(src, format.Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"bytes"
+	"go/token"
+)
+
+// Formatter formats many Go source files with the same Options. It reuses
+// memory, as well as a token.FileSet, between calls to Format, so it is cheaper
+// to use in a loop than Source.
+//
+// The zero value is ready to use. A Formatter is not safe for concurrent use.
+type Formatter struct {
+	Options Options
+
+	buf  bytes.Buffer
+	fset *token.FileSet
+}
+
+// Format is like Source, but it uses the Formatter's options. The filename is
+// only used for error messages, and may be empty.
+func (f *Formatter) Format(filename string, src []byte) ([]byte, error) {
+	f.buf.Reset()
+	if f.fset == nil {
+		f.fset = token.NewFileSet()
+	}
+	res, err := source(f.fset, filename, src, f.Options, &f.buf, nil)
+
+	// Remove the parsed file, even on errors, so that the FileSet doesn't
+	// keep growing. Its base still grows, so positions stay unique.
+	var files []*token.File
+	f.fset.Iterate(func(file *token.File) bool {
+		files = append(files, file)
+		return true
+	})
+	for _, file := range files {
+		f.fset.RemoveFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The result may point into our buffer, which we'll reuse.
+	return append([]byte(nil), res...), nil
+}
+
+// Reset releases the memory that the Formatter holds on to between calls to
+// Format, which is useful to trim the memory used by long-lived Formatters.
+// The Formatter can still be used afterwards.
+//
+// Like Format, Reset must not be called concurrently with any other method.
+func (f *Formatter) Reset() {
+	f.buf = bytes.Buffer{}
+	f.fset = nil
+}
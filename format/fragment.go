@@ -0,0 +1,114 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// Fragment is like Source, but src may also hold a list of declarations or a
+// list of statements, like with go/format.Source. A single expression is
+// formatted as a list with one statement. The fragment is formatted in a
+// synthetic file, which isn't part of the result.
+//
+// As with go/format.Source, the leading and trailing space in src is kept, and
+// the indentation of the first line of a fragment is applied to all of its
+// lines. If src is a complete file, it is formatted like with Source,
+// including StampHeader; otherwise, StampHeader is ignored, as a fragment has
+// no file header to stamp.
+func Fragment(src []byte, opts Options) ([]byte, error) {
+	res, err := Source(src, opts)
+	if err == nil || !strings.Contains(err.Error(), "expected 'package'") {
+		return res, err
+	}
+
+	// Insert the package clause and func header using ';', not a newline,
+	// so that the line numbers match the ones in src.
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", append([]byte("package p;"), src...), parser.ParseComments)
+	stmts := false
+	if err != nil {
+		if !strings.Contains(err.Error(), "expected declaration") {
+			return nil, err
+		}
+		// Add an extra newline before the '}' to make sure comments
+		// are flushed before it.
+		fset = token.NewFileSet()
+		fsrc := append(append([]byte("package p; func _() {"), src...), '\n', '\n', '}')
+		if file, err = parser.ParseFile(fset, "", fsrc, parser.ParseComments); err != nil {
+			return nil, err
+		}
+		stmts = true
+	}
+	formatFile(fset, file, opts, false)
+
+	// Keep the leading space, up to the first line with code.
+	i, j := 0, 0
+	for j < len(src) && isSpace(src[j]) {
+		if src[j] == '\n' {
+			i = j + 1
+		}
+		j++
+	}
+	var buf bytes.Buffer
+	buf.Write(src[:i])
+
+	// Spaces only count as one level of indentation if there are no tabs.
+	indent := strings.Count(string(src[i:j]), "\t")
+	if indent == 0 && j > i {
+		indent = 1
+	}
+	unit := "\t"
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if opts.IndentStyle.Spaces > 0 {
+		unit = strings.Repeat(" ", opts.IndentStyle.Spaces)
+		cfg = printer.Config{Mode: printer.UseSpaces, Tabwidth: opts.IndentStyle.Spaces}
+	}
+	buf.WriteString(strings.Repeat(unit, indent))
+
+	cfg.Indent = indent
+	if stmts {
+		// The func body would otherwise be indented one more level.
+		cfg.Indent--
+	}
+	if !opts.PreserveNonStdImportOrder {
+		ast.SortImports(fset, file)
+	}
+	var out bytes.Buffer
+	if err := cfg.Fprint(&out, fset, file); err != nil {
+		return nil, err
+	}
+
+	// Remove the synthetic file's lines: the package clause, and the func
+	// header and closing brace for statement lists.
+	res = out.Bytes()
+	res = res[bytes.IndexByte(res, '\n')+1:]
+	if stmts {
+		res = res[bytes.Index(res, []byte("func _() {"))+len("func _() {"):]
+		res = res[:bytes.LastIndexByte(res, '}')]
+	}
+	res = bytes.TrimSpace(res)
+	if len(res) == 0 {
+		return src, nil // nothing but space
+	}
+	buf.Write(res)
+
+	// Keep the trailing space.
+	i = len(src)
+	for i > 0 && isSpace(src[i-1]) {
+		i--
+	}
+	buf.Write(src[i:])
+	return buf.Bytes(), nil
+}
+
+// isSpace reports whether b is a space character, as defined by go/format.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// rxGoVersion matches the language version at the start of a go directive's
+// version, which may also have a suffix like "rc1".
+var rxGoVersion = regexp.MustCompile(`^[0-9]+\.[0-9]+`)
+
+// DetectLangVersion finds the go.mod file in dir or its closest parent
+// directory, and returns the version in its go directive, like "1.16", to be
+// used as Options.LangVersion. If there is no go.mod file, or it has no go
+// directive, "v1" is returned, like when LangVersion is empty.
+func DetectLangVersion(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, "go.mod")
+		data, err := os.ReadFile(path)
+		if err == nil {
+			file, err := modfile.ParseLax(path, data, nil)
+			if err != nil {
+				return "", err
+			}
+			if file.Go == nil {
+				return "v1", nil
+			}
+			version := file.Go.Version
+			if !semver.IsValid("v" + version) {
+				// e.g. "1.21rc1" isn't valid semver.
+				version = rxGoVersion.FindString(version)
+			}
+			if version == "" {
+				return "v1", nil
+			}
+			return version, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "v1", nil // reached the root
+		}
+		dir = parent
+	}
+}
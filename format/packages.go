@@ -0,0 +1,74 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// FormatPackages formats the Go files in pkgs, as loaded by go/packages with at
+// least packages.NeedFiles and packages.NeedSyntax, and returns the results
+// keyed by filename. The files are not read again, and the syntax trees in pkgs
+// are modified in place, so they shouldn't be used for analysis afterwards.
+//
+// Since go/packages only sets Package.Fset when loading types, fset must be
+// the FileSet which was used to load pkgs, set via packages.Config.Fset.
+//
+// If opts.LangVersion is empty, each package's module Go version is used, if
+// loaded via packages.NeedModule. Files which appear in more than one package,
+// such as in test variants, are only formatted once. Files generated by cgo or
+// go test are skipped.
+func FormatPackages(fset *token.FileSet, pkgs []*packages.Package, opts Options) (map[string][]byte, error) {
+	results := make(map[string][]byte)
+	var buf bytes.Buffer
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue // a test main package, generated by go test
+		}
+		if len(pkg.GoFiles) > 0 && len(pkg.Syntax) == 0 {
+			return nil, fmt.Errorf("package %s has no syntax trees; load it with packages.NeedSyntax", pkg.ID)
+		}
+		for _, err := range pkg.Errors {
+			if err.Kind == packages.ParseError {
+				return nil, err
+			}
+		}
+		pkgOpts := opts
+		if pkgOpts.LangVersion == "" && pkg.Module != nil {
+			pkgOpts.LangVersion = pkg.Module.GoVersion
+		}
+		goFiles := make(map[string]bool, len(pkg.GoFiles))
+		for _, name := range pkg.GoFiles {
+			goFiles[name] = true
+		}
+		for _, file := range pkg.Syntax {
+			// All the files share fset, but each has its own
+			// token.File, which is all that File modifies.
+			tf := fset.File(file.Pos())
+			if tf == nil {
+				return nil, fmt.Errorf("package %s wasn't loaded with the given FileSet", pkg.ID)
+			}
+			filename := tf.Name()
+			if !goFiles[filename] {
+				continue // generated by cgo
+			}
+			if _, ok := results[filename]; ok {
+				continue // already formatted in another package
+			}
+			File(fset, file, pkgOpts)
+			buf.Reset()
+			res, err := printFile(fset, file, pkgOpts, &buf)
+			if err != nil {
+				return nil, err
+			}
+			results[filename] = append([]byte(nil), res...)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// unifyReceiverNames renames the receivers of methods to the most common
+// receiver name used by the methods of the same type in the file, along with
+// their uses, returning the positions of the renamed receivers. Ties are broken
+// by the order of the methods.
+//
+// Without type information, uses are found via the objects resolved by the
+// parser. A method is left alone if its receiver isn't resolved, or if the new
+// name already appears anywhere in the method, as renaming could then change
+// which declaration an identifier refers to.
+func unifyReceiverNames(file *ast.File) []token.Pos {
+	type typeNames struct {
+		methods []*ast.FuncDecl
+		counts  map[string]int
+		order   []string
+	}
+	types := make(map[string]*typeNames)
+	var typeOrder []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || len(fn.Recv.List[0].Names) != 1 {
+			continue
+		}
+		name := fn.Recv.List[0].Names[0].Name
+		if name == "_" {
+			continue
+		}
+		typ := recvTypeName(fn.Recv.List[0].Type)
+		if typ == "" {
+			continue
+		}
+		names := types[typ]
+		if names == nil {
+			names = &typeNames{counts: make(map[string]int)}
+			types[typ] = names
+			typeOrder = append(typeOrder, typ)
+		}
+		names.methods = append(names.methods, fn)
+		if names.counts[name] == 0 {
+			names.order = append(names.order, name)
+		}
+		names.counts[name]++
+	}
+
+	var renamed []token.Pos
+	for _, typ := range typeOrder {
+		names := types[typ]
+		if len(names.order) < 2 {
+			continue // already consistent
+		}
+		common := names.order[0]
+		for _, name := range names.order[1:] {
+			if names.counts[name] > names.counts[common] {
+				common = name
+			}
+		}
+		for _, fn := range names.methods {
+			if renameReceiver(fn, common) {
+				renamed = append(renamed, fn.Recv.List[0].Names[0].Pos())
+			}
+		}
+	}
+	return renamed
+}
+
+// renameReceiver renames the receiver of a method to name, along with its uses,
+// reporting whether it did so.
+func renameReceiver(fn *ast.FuncDecl, name string) bool {
+	recv := fn.Recv.List[0].Names[0]
+	if recv.Name == name || recv.Obj == nil {
+		return false
+	}
+	safe := true
+	var uses []*ast.Ident
+	ast.Inspect(fn, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return safe
+		}
+		switch {
+		case ident.Obj == recv.Obj:
+			uses = append(uses, ident)
+		case ident.Name == name:
+			safe = false
+		}
+		return safe
+	})
+	if !safe {
+		return false
+	}
+	for _, ident := range uses {
+		ident.Name = name
+	}
+	recv.Obj.Name = name
+	return true
+}
@@ -2,7 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package format
+
+// This file is a copy of cmd/gofmt's simplify.go, along with the parts of its
+// rewrite.go which it needs to match types, to implement Options.Simplify.
 
 import (
 	"go/ast"
@@ -10,7 +13,10 @@ import (
 	"reflect"
 )
 
-type simplifier struct{}
+// simplifier calls record with the position of each simplification.
+type simplifier struct {
+	record func(token.Pos)
+}
 
 func (s simplifier) Visit(node ast.Node) ast.Visitor {
 	switch n := node.(type) {
@@ -61,6 +67,7 @@ func (s simplifier) Visit(node ast.Node) ast.Visitor {
 			// - 3-index slices always require the 2nd and 3rd index
 			break
 		}
+		record := s.record
 		if s, _ := n.X.(*ast.Ident); s != nil && s.Obj != nil {
 			// the array/slice object is a single, resolved identifier
 			if call, _ := n.High.(*ast.CallExpr); call != nil && len(call.Args) == 1 && !call.Ellipsis.IsValid() {
@@ -70,6 +77,7 @@ func (s simplifier) Visit(node ast.Node) ast.Visitor {
 					// because we don't have dot imports, it must be the predefined len()
 					if arg, _ := call.Args[0].(*ast.Ident); arg != nil && arg.Obj == s.Obj {
 						// the len argument is the array/slice object
+						record(n.High.Pos())
 						n.High = nil
 					}
 				}
@@ -89,9 +97,11 @@ func (s simplifier) Visit(node ast.Node) ast.Visitor {
 		// - a range of the form: for _ = range v {...}
 		// can be simplified to: for range v {...}
 		if isBlank(n.Value) {
+			s.record(n.Value.Pos())
 			n.Value = nil
 		}
 		if isBlank(n.Key) && n.Value == nil {
+			s.record(n.Key.Pos())
 			n.Key = nil
 		}
 	}
@@ -106,7 +116,8 @@ func (s simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *
 	// matches the outer literal's element type exactly, the inner
 	// literal type may be omitted
 	if inner, ok := x.(*ast.CompositeLit); ok {
-		if match(nil, typ, reflect.ValueOf(inner.Type)) {
+		if match(typ, reflect.ValueOf(inner.Type)) {
+			s.record(inner.Pos())
 			inner.Type = nil
 		}
 	}
@@ -116,7 +127,8 @@ func (s simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *
 	if ptr, ok := astType.(*ast.StarExpr); ok {
 		if addr, ok := x.(*ast.UnaryExpr); ok && addr.Op == token.AND {
 			if inner, ok := addr.X.(*ast.CompositeLit); ok {
-				if match(nil, reflect.ValueOf(ptr.X), reflect.ValueOf(inner.Type)) {
+				if match(reflect.ValueOf(ptr.X), reflect.ValueOf(inner.Type)) {
+					s.record(addr.Pos())
 					inner.Type = nil // drop T
 					*px = inner      // drop &
 				}
@@ -130,20 +142,24 @@ func isBlank(x ast.Expr) bool {
 	return ok && ident.Name == "_"
 }
 
-func simplify(f *ast.File) {
+// simplify applies the simplifications to f, calling record with the position
+// of each of them.
+func simplify(f *ast.File, record func(token.Pos)) {
 	// remove empty declarations such as "const ()", etc
-	removeEmptyDeclGroups(f)
+	removeEmptyDeclGroups(f, record)
 
-	var s simplifier
+	s := simplifier{record: record}
 	ast.Walk(s, f)
 }
 
-func removeEmptyDeclGroups(f *ast.File) {
+func removeEmptyDeclGroups(f *ast.File, record func(token.Pos)) {
 	i := 0
 	for _, d := range f.Decls {
 		if g, ok := d.(*ast.GenDecl); !ok || !isEmpty(f, g) {
 			f.Decls[i] = d
 			i++
+		} else {
+			record(g.Pos())
 		}
 	}
 	f.Decls = f.Decls[:i]
@@ -163,3 +179,80 @@ func isEmpty(f *ast.File, g *ast.GenDecl) bool {
 
 	return true
 }
+
+// Values/types for special cases.
+var (
+	identType     = reflect.TypeOf((*ast.Ident)(nil))
+	objectPtrType = reflect.TypeOf((*ast.Object)(nil))
+	positionType  = reflect.TypeOf(token.NoPos)
+	callExprType  = reflect.TypeOf((*ast.CallExpr)(nil))
+)
+
+// match checks whether pattern == val. Unlike in gofmt, wildcards aren't
+// supported, as they're only used by its -r flag.
+func match(pattern, val reflect.Value) bool {
+	// pattern and val must match recursively.
+	if !pattern.IsValid() || !val.IsValid() {
+		return !pattern.IsValid() && !val.IsValid()
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+
+	// Special cases.
+	switch pattern.Type() {
+	case identType:
+		// For identifiers, only the names need to match
+		// (and none of the other *ast.Object information).
+		// This is a common case, handle it all here instead
+		// of recursing down any further via reflection.
+		p := pattern.Interface().(*ast.Ident)
+		v := val.Interface().(*ast.Ident)
+		return p == nil && v == nil || p != nil && v != nil && p.Name == v.Name
+	case objectPtrType, positionType:
+		// object pointers and token positions always match
+		return true
+	case callExprType:
+		// For calls, the Ellipsis fields (token.Position) must
+		// match since that is how f(x) and f(x...) are different.
+		// Check them here but fall through for the remaining fields.
+		p := pattern.Interface().(*ast.CallExpr)
+		v := val.Interface().(*ast.CallExpr)
+		if p.Ellipsis.IsValid() != v.Ellipsis.IsValid() {
+			return false
+		}
+	}
+
+	p := reflect.Indirect(pattern)
+	v := reflect.Indirect(val)
+	if !p.IsValid() || !v.IsValid() {
+		return !p.IsValid() && !v.IsValid()
+	}
+
+	switch p.Kind() {
+	case reflect.Slice:
+		if p.Len() != v.Len() {
+			return false
+		}
+		for i := 0; i < p.Len(); i++ {
+			if !match(p.Index(i), v.Index(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Struct:
+		for i := 0; i < p.NumField(); i++ {
+			if !match(p.Field(i), v.Field(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Interface:
+		return match(p.Elem(), v.Elem())
+	}
+
+	// Handle token integers, etc.
+	return p.Interface() == v.Interface()
+}
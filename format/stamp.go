@@ -0,0 +1,76 @@
+// Copyright (c) 2021, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package format
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"runtime/debug"
+)
+
+const stampPrefix = "//gofumpt:version "
+
+// version returns the version of the gofumpt module in use, like
+// "v0.1.1". It follows runtime/debug in returning "(devel)" otherwise.
+func version() string {
+	const modulePath = "mvdan.cc/gofumpt"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	mods := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, mod := range mods {
+		if mod.Path != modulePath {
+			continue
+		}
+		if mod.Replace != nil {
+			mod = mod.Replace
+		}
+		if mod.Version != "" {
+			return mod.Version
+		}
+	}
+	return "(devel)"
+}
+
+// stampHeader inserts or updates the "//gofumpt:version" comment in src, which
+// must already be formatted. Working on the printed source is simpler than
+// working on the AST, as the stamp needs new lines around it which don't exist
+// in the original source.
+func stampHeader(src []byte) []byte {
+	stamp := []byte(stampPrefix + version())
+	lines := bytes.SplitAfter(src, []byte("\n"))
+
+	// Only look at the lines before the package clause.
+	for i, line := range lines {
+		if bytes.HasPrefix(line, []byte("package ")) {
+			break
+		}
+		if bytes.HasPrefix(line, []byte(stampPrefix)) {
+			lines[i] = append(stamp, '\n')
+			return bytes.Join(lines, nil)
+		}
+	}
+
+	// The stamp goes right before the package documentation, so after any
+	// leading comments such as build constraints or a license header, and
+	// the empty line following them. It is followed by an empty line of its
+	// own so that it doesn't become part of the package documentation.
+	at := 0
+	fset := token.NewFileSet()
+	if file, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly|parser.ParseComments); err == nil {
+		pos := file.Package
+		if file.Doc != nil {
+			pos = file.Doc.Pos()
+		}
+		at = fset.Position(pos).Line - 1
+	}
+	var buf bytes.Buffer
+	buf.Write(bytes.Join(lines[:at], nil))
+	buf.Write(stamp)
+	buf.WriteString("\n\n")
+	buf.Write(bytes.Join(lines[at:], nil))
+	return buf.Bytes()
+}
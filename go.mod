@@ -1,11 +1,16 @@
 module mvdan.cc/gofumpt
 
-go 1.15
+go 1.21
 
 require (
 	github.com/google/go-cmp v0.5.4
 	github.com/rogpeppe/go-internal v1.7.1-0.20210301144926-2630b2f15b04
-	golang.org/x/mod v0.4.0
-	golang.org/x/tools v0.0.0-20210101214203-2dba1e4ea05c
+	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4
+	golang.org/x/tools v0.1.12
+)
+
+require (
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
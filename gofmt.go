@@ -121,10 +121,6 @@ func processFile(filename string, in io.Reader, out io.Writer, stdin bool) error
 
 	ast.SortImports(fileSet, file)
 
-	if *simplifyAST {
-		simplify(file)
-	}
-
 	// Apply gofumpt's changes before we print the code in gofumpt's format.
 
 	if *langVersion == "" {
@@ -138,6 +134,7 @@ func processFile(filename string, in io.Reader, out io.Writer, stdin bool) error
 	gformat.File(fileSet, file, gformat.Options{
 		LangVersion: *langVersion,
 		ExtraRules:  *extraRules,
+		Simplify:    *simplifyAST,
 	})
 
 	res, err := format(fileSet, file, sourceAdj, indentAdj, src, printer.Config{Mode: printerMode, Tabwidth: tabWidth})